@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/unappendixed/bootdevpubsub/internal/bot"
+	"github.com/unappendixed/bootdevpubsub/internal/pubsub"
+	"github.com/unappendixed/bootdevpubsub/internal/routing"
+)
+
+const (
+	defaultBotName     = "bot"
+	defaultBotStrategy = "random"
+	defaultBotInterval = 2 * time.Second
+	lobbyTimeout       = 10 * time.Second
+)
+
+// bot runs computer players, to fill games or play them unattended. Every bot
+// shares one connection and runs until its game ends or it is interrupted.
+func main() {
+	godotenv.Load(".env")
+
+	connstr, found := os.LookupEnv("RABBITMQ_CONN_STRING")
+	if !found {
+		panic("AMQP connection string not found!")
+	}
+
+	name := defaultBotName
+	if value, found := os.LookupEnv("BOT_NAME"); found {
+		name = value
+	}
+
+	count := 1
+	if value, found := os.LookupEnv("BOT_COUNT"); found {
+		var err error
+		count, err = strconv.Atoi(value)
+		if err != nil || count <= 0 {
+			panic(fmt.Errorf("Invalid BOT_COUNT %q", value))
+		}
+	}
+
+	interval := defaultBotInterval
+	if value, found := os.LookupEnv("BOT_INTERVAL"); found {
+		var err error
+		interval, err = time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			panic(fmt.Errorf("Invalid BOT_INTERVAL %q", value))
+		}
+	}
+
+	seed := uint64(time.Now().UnixNano())
+	if value, found := os.LookupEnv("BOT_SEED"); found {
+		var err error
+		seed, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			panic(fmt.Errorf("Invalid BOT_SEED %q", value))
+		}
+	}
+
+	// Strategies are handed out to the bots in turn.
+	strategyNames := []string{defaultBotStrategy}
+	if value, found := os.LookupEnv("BOT_STRATEGY"); found {
+		strategyNames = strings.Split(value, ",")
+	}
+	strategies := []bot.Strategy{}
+	for _, strategyName := range strategyNames {
+		strategy, err := bot.NewStrategy(strings.TrimSpace(strategyName))
+		if err != nil {
+			panic(fmt.Errorf("Invalid BOT_STRATEGY: %w", err))
+		}
+		strategies = append(strategies, strategy)
+	}
+
+	conn, err := pubsub.Dial(connstr)
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+
+	gameID, found := os.LookupEnv("BOT_GAME")
+	if !found {
+		gameID, err = createGame(conn, name)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Started game %s\n", gameID)
+	}
+
+	done := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		close(done)
+	}()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < count; i++ {
+		username := name
+		if count > 1 {
+			username = fmt.Sprintf("%s%d", name, i+1)
+		}
+		b := bot.New(username, gameID, strategies[i%len(strategies)], seed)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := b.Run(conn, interval, done)
+			if err != nil {
+				fmt.Printf("%s stopped: %v\n", username, err)
+			}
+		}()
+	}
+	fmt.Printf("Running %d bot(s) in game %s. Press Ctrl+C to stop.\n", count, gameID)
+	wg.Wait()
+}
+
+// createGame asks the server to start a new game on its default map.
+func createGame(conn pubsub.Broker, username string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), lobbyTimeout)
+	defer cancel()
+	reply, err := pubsub.Call[routing.LobbyRequest, routing.LobbyReply](
+		ctx,
+		conn,
+		routing.ExchangePerilTopic,
+		fmt.Sprintf("%s.%s", routing.LobbyPrefix, username),
+		routing.LobbyRequest{Username: username, Kind: routing.LobbyCreate},
+	)
+	if errors.Is(err, pubsub.CallTimeoutErr) {
+		return "", errors.New("the server did not answer. is it running?")
+	}
+	if err != nil {
+		return "", err
+	}
+	return reply.Created, nil
+}
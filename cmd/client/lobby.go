@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/unappendixed/bootdevpubsub/internal/gamelogic"
+	"github.com/unappendixed/bootdevpubsub/internal/pubsub"
+	"github.com/unappendixed/bootdevpubsub/internal/routing"
+)
+
+// How long to wait for the server to answer a lobby request.
+const lobbyTimeout = 10 * time.Second
+
+// How long to wait for matchmaking to find a game. The server drops requests
+// after the same time.
+const matchTimeout = 5 * time.Minute
+
+// pickGame lists the server's games and lets the player join or watch one,
+// start a new one, load one they saved or be matched with other players. The
+// choice it returns always has a game ID.
+func pickGame(conn pubsub.Broker, username string) (gamelogic.LobbyChoice, error) {
+	reply, err := requestLobby(conn, routing.LobbyRequest{Username: username, Kind: routing.LobbyList})
+	if err != nil {
+		return gamelogic.LobbyChoice{}, err
+	}
+	choice, err := gamelogic.ClientPickGame(username, reply.Games)
+	if err != nil {
+		return choice, err
+	}
+	if choice.Spectate {
+		_, err = requestLobby(conn, routing.LobbyRequest{Username: username, Kind: routing.LobbyWatch, GameID: choice.GameID})
+		return choice, err
+	}
+	if choice.GameID != "" {
+		return choice, nil
+	}
+	if choice.Match != nil {
+		choice.GameID, err = findMatch(conn, *choice.Match)
+		return choice, err
+	}
+	if choice.Load {
+		choice.GameID, err = loadGame(conn, username, choice.SaveFile)
+		return choice, err
+	}
+
+	reply, err = requestLobby(conn, routing.LobbyRequest{Username: username, Kind: routing.LobbyCreate})
+	if err != nil {
+		return choice, err
+	}
+	fmt.Printf("Started game %s\n", reply.Created)
+	choice.GameID = reply.Created
+	return choice, nil
+}
+
+// loadGame sends a save to the server, which picks the game up from it as a
+// new game. The server's copy is the one that counts from then on.
+func loadGame(conn pubsub.Broker, username string, path string) (string, error) {
+	if path == "" {
+		path = defaultSavePath(username)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read save: %v", err)
+	}
+	reply, err := requestLobby(conn, routing.LobbyRequest{Username: username, Kind: routing.LobbyLoad, Save: data})
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Loaded %s as game %s\n", path, reply.Created)
+	return reply.Created, nil
+}
+
+func requestLobby(conn pubsub.Broker, req routing.LobbyRequest) (routing.LobbyReply, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), lobbyTimeout)
+	defer cancel()
+	reply, err := pubsub.Call[routing.LobbyRequest, routing.LobbyReply](
+		ctx,
+		conn,
+		routing.ExchangePerilTopic,
+		fmt.Sprintf("%s.%s", routing.LobbyPrefix, req.Username),
+		req,
+	)
+	if errors.Is(err, pubsub.CallTimeoutErr) {
+		return reply, errors.New("the server did not answer. is it running?")
+	}
+	return reply, err
+}
+
+// findMatch queues the player for matchmaking and waits for the server to put
+// them in a game.
+func findMatch(conn pubsub.Broker, req routing.MatchRequest) (string, error) {
+	matches := make(chan routing.Match, 1)
+	err := pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilDirect,
+		fmt.Sprintf("%s.%s", routing.MatchesPrefix, req.Username),
+		"",
+		pubsub.QueueTypeTransient,
+		func(match routing.Match) pubsub.AckType {
+			select {
+			case matches <- match:
+			default:
+			}
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("Failed to subscribe to matchmaking: %w", err)
+	}
+
+	key := fmt.Sprintf("%s.%s", routing.MatchmakingPrefix, req.Username)
+	err = pubsub.PublishBrokerJSON(conn, routing.ExchangePerilTopic, key, req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to request a match: %w", err)
+	}
+	fmt.Println("Waiting for other players...")
+
+	select {
+	case match := <-matches:
+		if match.Error != "" {
+			return "", errors.New(match.Error)
+		}
+		fmt.Printf("Matched with %s in game %s on %s\n", strings.Join(match.Players, ", "), match.GameID, match.Map)
+		return match.GameID, nil
+	case <-time.After(matchTimeout):
+		pubsub.PublishBrokerJSON(conn, routing.ExchangePerilTopic, key, routing.MatchRequest{Username: req.Username, Cancel: true})
+		return "", errors.New("nobody else wanted the same kind of game. try again later")
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"time"
 
 	"github.com/joho/godotenv"
-	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/unappendixed/bootdevpubsub/internal/gamelogic"
 	"github.com/unappendixed/bootdevpubsub/internal/pubsub"
 	"github.com/unappendixed/bootdevpubsub/internal/routing"
@@ -33,7 +32,7 @@ func main() {
 	logger = *log.New(logfile, "", log.Ldate|log.Ltime)
 	fmt.Println("Starting Peril client...")
 
-	conn, err := amqp.Dial(connstr)
+	conn, err := pubsub.Dial(connstr)
 	if err != nil {
 		panic(err)
 	}
@@ -41,71 +40,194 @@ func main() {
 
 	input, err := gamelogic.ClientWelcome()
 
-	pauseKey := fmt.Sprintf("%s.%s", routing.PauseKey, input)
+	choice, err := pickGame(conn, input)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	gameID := choice.GameID
+	presence := routing.PlayerPresence{Username: input, GameID: gameID}
+	if choice.Spectate {
+		presence.Spectator = true
+		spectate(conn, presence)
+		return
+	}
+
+	pauseKey := routing.GameKey(routing.PauseKey, gameID, input)
+
+	// Outgoing intents
+	intentKey := routing.GameKey(routing.IntentsPrefix, gameID, input)
 
-	_, _, err = pubsub.DeclareAndBind(
+	savePath := defaultSavePath(input)
+
+	gamestate := gamelogic.NewGameState(input)
+	gamestate.SetGameID(gameID)
+
+	// Incoming game info
+	err = pubsub.SubscribeBrokerJSON[gamelogic.GameInfo](
 		conn,
-		routing.ExchangePerilDirect,
-		pauseKey,
-		routing.PauseKey,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.GameInfoPrefix, gameID, input),
+		"",
 		pubsub.QueueTypeTransient,
+		handlerGameInfo(gamestate),
 	)
 	if err != nil {
-		panic(err)
+		panic(fmt.Errorf("Failed to subscribe to game info: %w", err))
 	}
 
-	armyKey := fmt.Sprintf("%s.%s", routing.ArmyMovesPrefix, input)
+	// Incoming authoritative state
+	err = pubsub.SubscribeBrokerJSON[gamelogic.StateUpdate](
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.StatePrefix, gameID, input),
+		"",
+		pubsub.QueueTypeTransient,
+		handlerStateUpdate(gamestate),
+	)
+	if err != nil {
+		panic(fmt.Errorf("Failed to subscribe to state updates: %w", err))
+	}
 
-    // Outgoing army moves
-	_, _, err = pubsub.DeclareAndBind(
+	// Incoming turns
+	err = pubsub.SubscribeBrokerJSON[gamelogic.Turn](
 		conn,
 		routing.ExchangePerilTopic,
+		routing.GameKey(routing.TurnKey, gameID),
 		"",
-		armyKey,
 		pubsub.QueueTypeTransient,
+		handlerTurn(gamestate),
 	)
+	if err != nil {
+		panic(fmt.Errorf("Failed to subscribe to turns: %w", err))
+	}
 
-	gamestate := gamelogic.NewGameState(input)
+	// Incoming war results
+	err = pubsub.SubscribeBrokerJSON[gamelogic.WarResult](
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.WarResultsPrefix, gameID, "*"),
+		"",
+		pubsub.QueueTypeTransient,
+		handlerWarResult(gamestate),
+	)
+	if err != nil {
+		panic(fmt.Errorf("Failed to subscribe to war results: %w", err))
+	}
 
-    // Incoming wars
-	err = pubsub.SubscribeJSON[gamelogic.RecognitionOfWar](
+    // Incoming playing state, for the whole game and for us alone, on one
+    // queue
+	for _, key := range []string{routing.GameKey(routing.PauseKey, gameID), pauseKey} {
+		err = pubsub.SubscribeBrokerJSON[routing.PlayingState](
+			conn,
+			routing.ExchangePerilDirect,
+			key,
+			pauseKey,
+			pubsub.QueueTypeTransient,
+			handlerPause(gamestate),
+		)
+		if err != nil {
+			panic(fmt.Errorf("Failed to subscribe to server pause state: %w", err))
+		}
+	}
+
+    // Incoming moves, filtered by the server to what we can see
+	err = pubsub.SubscribeBrokerJSON[gamelogic.ArmyMove](
 		conn,
 		routing.ExchangePerilTopic,
-		fmt.Sprintf("%s.*", routing.WarRecognitionsPrefix),
-		"war",
-		pubsub.QueueTypeDurable,
-		handlerWar(gamestate, conn),
+		routing.GameKey(routing.ArmyMovesPrefix, gameID, input),
+		"",
+		pubsub.QueueTypeTransient,
+		handlerArmyMove(gamestate),
 	)
+	if err != nil {
+		panic(fmt.Errorf("Failed to subscribe to army moves: %w", err))
+	}
 
-    // Incoming playing state
-	err = pubsub.SubscribeJSON[routing.PlayingState](
+	// Incoming announcements
+	err = pubsub.SubscribeBrokerJSON[routing.Announcement](
 		conn,
 		routing.ExchangePerilDirect,
-		routing.PauseKey,
-		pauseKey,
+		routing.AnnouncementKey,
+		"",
 		pubsub.QueueTypeTransient,
-		handlerPause(gamestate),
+		handlerAnnouncement(),
 	)
 	if err != nil {
-		panic(fmt.Errorf("Failed to subscribe to server pause state: %w", err))
+		panic(fmt.Errorf("Failed to subscribe to announcements: %w", err))
 	}
 
-    // Incoming moves
-	err = pubsub.SubscribeJSON[gamelogic.ArmyMove](
+	// Incoming diplomacy
+	err = pubsub.SubscribeBrokerJSON[gamelogic.Diplomacy](
 		conn,
 		routing.ExchangePerilTopic,
-		fmt.Sprintf("%s.*", routing.ArmyMovesPrefix),
+		routing.GameKey(routing.DiplomacyPrefix, gameID, input),
 		"",
 		pubsub.QueueTypeTransient,
-		handlerArmyMove(gamestate, conn, input),
+		handlerDiplomacy(gamestate),
 	)
 	if err != nil {
-		panic(fmt.Errorf("Failed to subscribe to army moves: %w", err))
+		panic(fmt.Errorf("Failed to subscribe to diplomacy: %w", err))
+	}
+
+	// Incoming game over
+	gameOver := make(chan struct{}, 1)
+	err = pubsub.SubscribeBrokerJSON[gamelogic.GameOver](
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.GameOverKey, gameID),
+		"",
+		pubsub.QueueTypeTransient,
+		handlerGameOver(gamestate, gameOver),
+	)
+	if err != nil {
+		panic(fmt.Errorf("Failed to subscribe to game over: %w", err))
+	}
+
+	// Incoming kicks
+	kicked := make(chan string, 1)
+	err = pubsub.SubscribeBrokerJSON[routing.Kick](
+		conn,
+		routing.ExchangePerilDirect,
+		fmt.Sprintf("%s.%s", routing.KickPrefix, input),
+		"",
+		pubsub.QueueTypeTransient,
+		handlerKick(kicked),
+	)
+	if err != nil {
+		panic(fmt.Errorf("Failed to subscribe to kicks: %w", err))
 	}
 
+	err = publishPresence(conn, presence, routing.PresenceJoin)
+	if err != nil {
+		panic(fmt.Errorf("Failed to announce join: %w", err))
+	}
+	heartbeatDone := make(chan struct{})
+	go heartbeat(conn, presence, heartbeatDone)
+	defer func() {
+		close(heartbeatDone)
+		publishPresence(conn, presence, routing.PresenceLeave)
+	}()
+
+	commands := make(chan []string)
+	go func() {
+		for {
+			commands <- gamelogic.GetInput()
+		}
+	}()
+
 outer:
 	for {
-		input := gamelogic.GetInput()
+		var input []string
+		select {
+		case reason := <-kicked:
+			fmt.Println()
+			fmt.Printf("You have been kicked from the server: %s\n", reason)
+			break outer
+		case <-gameOver:
+			break outer
+		case input = <-commands:
+		}
 
 		if len(input) == 0 {
 			continue
@@ -113,42 +235,72 @@ outer:
 
 		switch input[0] {
 		case "quit":
+			err := gamestate.Save(savePath)
+			if err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Printf("Saved your game to %s\n", savePath)
+			}
 			gamelogic.PrintQuit()
 			break outer
+		case "save":
+			path := savePath
+			if len(input) > 1 {
+				path = input[1]
+			}
+			err := gamestate.Save(path)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Printf("Saved your game to %s\n", path)
 		case "spawn":
 			if len(input) != 3 {
 				fmt.Println("Spawn command must specify exactly two args")
 				continue
 			}
 
-			gamestate.CommandSpawn(input)
+			intent, err := gamestate.CommandSpawn(input)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			err = pubsub.PublishBrokerJSON(conn, routing.ExchangePerilTopic, intentKey, intent)
+			if err != nil {
+				fmt.Println(fmt.Errorf("Failed to send spawn: %w", err))
+				continue
+			}
 
 		case "move":
-			if len(input) != 3 {
-				fmt.Println("Move command must specify exactly two args")
+			if len(input) < 3 {
+				fmt.Println("Move command must specify a location and at least one unit")
 				continue
 			}
-			move, err := gamestate.CommandMove(input)
+			intent, err := gamestate.CommandMove(input)
 			if err != nil {
 				fmt.Println(err)
+				continue
 			}
 
-            ch, err := conn.Channel()
-            if err != nil {
-				panic(fmt.Errorf("Failed to publish army move: %w", err))
-            }
-            defer ch.Close()
-			err = pubsub.PublishJSON[gamelogic.ArmyMove](
-				ch,
-				routing.ExchangePerilTopic,
-				armyKey,
-				move,
-			)
+			err = pubsub.PublishBrokerJSON(conn, routing.ExchangePerilTopic, intentKey, intent)
 			if err != nil {
-				panic(fmt.Errorf("Failed to publish army move: %w", err))
+				fmt.Println(fmt.Errorf("Failed to send move: %w", err))
+				continue
 			}
 
-			fmt.Println("Move was published to other players.")
+			fmt.Println("Move was sent to the server.")
+
+		case "propose", "accept", "break":
+			intent, err := gamestate.CommandDiplomacy(input)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			err = pubsub.PublishBrokerJSON(conn, routing.ExchangePerilTopic, intentKey, intent)
+			if err != nil {
+				fmt.Println(fmt.Errorf("Failed to send %s: %w", input[0], err))
+				continue
+			}
 
 		case "help":
 			gamelogic.PrintClientHelp()
@@ -156,6 +308,9 @@ outer:
 		case "status":
 			gamestate.CommandStatus()
 
+		case "map":
+			gamestate.CommandMap()
+
 		case "spam":
             if len(input) != 2 {
                 fmt.Println("Spam command must specify exactly one argument.")
@@ -168,20 +323,13 @@ outer:
                 continue
             }
 
-            ch, err := conn.Channel()
-            if err != nil {
-                fmt.Println(err)
-                continue
-            }
-            defer ch.Close()
-
-            logstr := gamelogic.GetMaliciousLog()
+            logstr := gamelogic.GetMaliciousLog(gamestate.Rand())
 
             for i := 0; i < count; i++ {
-                pubsub.PublishGob[routing.GameLog](
-                    ch,
+                pubsub.PublishBrokerGob[routing.GameLog](
+                    conn,
                     routing.ExchangePerilTopic,
-                    fmt.Sprintf("%s.%s", routing.GameLogSlug, gamestate.Player.Username),
+                    routing.GameKey(routing.GameLogSlug, gameID, gamestate.Player.Username),
                     routing.GameLog{
                         CurrentTime: time.Now(),
                         Message: logstr,
@@ -198,104 +346,79 @@ outer:
 	}
 }
 
-func handlerArmyMove(
-	gs *gamelogic.GameState,
-	conn *amqp.Connection,
-	username string) func(gamelogic.ArmyMove) pubsub.AckType {
-
+func handlerArmyMove(gs *gamelogic.GameState) func(gamelogic.ArmyMove) pubsub.AckType {
 	return func(am gamelogic.ArmyMove) pubsub.AckType {
 		defer fmt.Print("> ")
 		outcome := gs.HandleMove(am)
-        fmt.Println("Move received!")
+		fmt.Println("Move received!")
 
 		switch outcome {
-		case gamelogic.MoveOutComeSafe:
-			return pubsub.AckTypeAck
-		case gamelogic.MoveOutcomeMakeWar:
-			row := gamelogic.RecognitionOfWar{
-				Attacker: am.Player,
-				Defender: gs.Player,
-			}
-			ch, err := conn.Channel()
-			defer ch.Close()
-			if err != nil {
-				fmt.Println(err)
-				return pubsub.AckTypeNackRequeue
-			}
-			err = pubsub.PublishJSON[gamelogic.RecognitionOfWar](
-				ch,
-				routing.ExchangePerilTopic,
-				fmt.Sprintf("%s.%s", routing.WarRecognitionsPrefix, username),
-				row,
-			)
-			if err != nil {
-				fmt.Println(err)
-			}
-			return pubsub.AckTypeAck
-		case gamelogic.MoveOutcomeSamePlayer:
+		case gamelogic.MoveOutcomeSamePlayer, gamelogic.MoveOutcomeInvalid:
 			return pubsub.AckTypeNackDiscard
 		default:
+			// Wars are declared and fought by the server.
 			return pubsub.AckTypeAck
 		}
 	}
 }
 
-func handlerWar(gs *gamelogic.GameState, conn *amqp.Connection) func(gamelogic.RecognitionOfWar) pubsub.AckType {
-	return func(rw gamelogic.RecognitionOfWar) pubsub.AckType {
-        defer fmt.Print("> ")
-		outcome, winner, loser := gs.HandleWar(rw)
-
-        gamelog := routing.GameLog{
-            CurrentTime: time.Now(),
-            Username: gs.Player.Username,
-        }
-
-        ch, err := conn.Channel()
-        if err != nil {
-            return pubsub.AckTypeNackRequeue
-        }
-        defer ch.Close()
-
-        publish := func(gl routing.GameLog) {
-            pubsub.PublishGob[routing.GameLog](
-                ch,
-                routing.ExchangePerilTopic,
-                fmt.Sprintf("%s.%s", routing.GameLogSlug, rw.Attacker.Username),
-                gamelog,
-            )
-        }
-
-		switch outcome {
-		case gamelogic.WarOutcomeNotInvolved:
-			return pubsub.AckTypeNackRequeue
-		case gamelogic.WarOutcomeNoUnits:
+func handlerGameInfo(gs *gamelogic.GameState) func(gamelogic.GameInfo) pubsub.AckType {
+	return func(gi gamelogic.GameInfo) pubsub.AckType {
+		defer fmt.Print("> ")
+		err := gs.HandleGameInfo(gi)
+		if err != nil {
+			fmt.Println(err)
 			return pubsub.AckTypeNackDiscard
-		case gamelogic.WarOutcomeOpponentWon:
+		}
+		return pubsub.AckTypeAck
+	}
+}
 
-            gamelog.Message = fmt.Sprintf("%s won a war against %s", winner, loser )
-            publish(gamelog)
+func handlerStateUpdate(gs *gamelogic.GameState) func(gamelogic.StateUpdate) pubsub.AckType {
+	return func(su gamelogic.StateUpdate) pubsub.AckType {
+		if su.Message != "" || su.Error != "" {
+			defer fmt.Print("> ")
+		}
+		gs.HandleStateUpdate(su)
+		return pubsub.AckTypeAck
+	}
+}
 
+func handlerWarResult(gs *gamelogic.GameState) func(gamelogic.WarResult) pubsub.AckType {
+	return func(wr gamelogic.WarResult) pubsub.AckType {
+		if !wr.Involves(gs.GetUsername()) {
 			return pubsub.AckTypeAck
-		case gamelogic.WarOutcomeDraw:
-
-            gamelog.Message = fmt.Sprintf(
-                "A war between %s and %s resulted in a draw",
-                winner,
-                loser,
-            )
-            publish(gamelog)
+		}
+		defer fmt.Print("> ")
+		gs.HandleWarResult(wr)
+		return pubsub.AckTypeAck
+	}
+}
 
-			return pubsub.AckTypeAck
-		case gamelogic.WarOutcomeYouWon:
+func handlerTurn(gs *gamelogic.GameState) func(gamelogic.Turn) pubsub.AckType {
+	return func(turn gamelogic.Turn) pubsub.AckType {
+		defer fmt.Print("> ")
+		gs.HandleTurn(turn)
+		return pubsub.AckTypeAck
+	}
+}
 
-            gamelog.Message = fmt.Sprintf("%s won a war against %s", winner, loser )
-            publish(gamelog)
+func handlerDiplomacy(gs *gamelogic.GameState) func(gamelogic.Diplomacy) pubsub.AckType {
+	return func(d gamelogic.Diplomacy) pubsub.AckType {
+		defer fmt.Print("> ")
+		gs.HandleDiplomacy(d)
+		return pubsub.AckTypeAck
+	}
+}
 
-			return pubsub.AckTypeAck
+func handlerGameOver(gs *gamelogic.GameState, gameOver chan<- struct{}) func(gamelogic.GameOver) pubsub.AckType {
+	return func(over gamelogic.GameOver) pubsub.AckType {
+		gs.HandleGameOver(over)
+		select {
+		case gameOver <- struct{}{}:
 		default:
-			fmt.Printf("Invalid war outcome: %d", outcome)
-			return pubsub.AckTypeNackDiscard
 		}
+		return pubsub.AckTypeAck
 	}
 }
 
@@ -306,3 +429,27 @@ func handlerPause(gs *gamelogic.GameState) func(routing.PlayingState) pubsub.Ack
 		return pubsub.AckTypeAck
 	}
 }
+
+func handlerAnnouncement() func(routing.Announcement) pubsub.AckType {
+	return func(a routing.Announcement) pubsub.AckType {
+		defer fmt.Print("> ")
+		fmt.Println()
+		fmt.Printf("[%s] Server announcement: %s\n", a.CurrentTime.Format(time.Kitchen), a.Message)
+		return pubsub.AckTypeAck
+	}
+}
+
+func handlerKick(kicked chan<- string) func(routing.Kick) pubsub.AckType {
+	return func(k routing.Kick) pubsub.AckType {
+		select {
+		case kicked <- k.Reason:
+		default:
+		}
+		return pubsub.AckTypeAck
+	}
+}
+
+// defaultSavePath is where the player's game is saved unless they name a file.
+func defaultSavePath(username string) string {
+	return fmt.Sprintf("%s.save.json", username)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/unappendixed/bootdevpubsub/internal/pubsub"
+	"github.com/unappendixed/bootdevpubsub/internal/routing"
+)
+
+// publishPresence announces who is in which game. pp says who they are; its
+// kind and time are filled in here.
+func publishPresence(conn pubsub.Broker, pp routing.PlayerPresence, kind routing.PresenceKind) error {
+	pp.Kind = kind
+	pp.CurrentTime = time.Now()
+	return pubsub.PublishBrokerJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		fmt.Sprintf("%s.%s", routing.PresencePrefix, pp.Username),
+		pp,
+	)
+}
+
+// heartbeat publishes a presence heartbeat every interval until done is
+// closed.
+func heartbeat(conn pubsub.Broker, pp routing.PlayerPresence, done <-chan struct{}) {
+	ticker := time.NewTicker(routing.PresenceHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := publishPresence(conn, pp, routing.PresenceHeartbeat)
+			if err != nil {
+				logger.Printf("Failed to publish heartbeat: %v\n", err)
+			}
+		}
+	}
+}
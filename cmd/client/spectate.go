@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/unappendixed/bootdevpubsub/internal/gamelogic"
+	"github.com/unappendixed/bootdevpubsub/internal/pubsub"
+	"github.com/unappendixed/bootdevpubsub/internal/routing"
+)
+
+// spectate watches a game without playing in it. Spectators get every move,
+// war and result in the game, but can't give any orders.
+func spectate(conn pubsub.Broker, presence routing.PlayerPresence) {
+	spectator := gamelogic.NewSpectator(presence.Username)
+	gameID := presence.GameID
+
+	err := subscribeSpectator(conn, spectator, gameID)
+	if err != nil {
+		panic(err)
+	}
+
+	// Incoming game over
+	gameOver := make(chan struct{}, 1)
+	err = pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.GameOverKey, gameID),
+		"",
+		pubsub.QueueTypeTransient,
+		func(over gamelogic.GameOver) pubsub.AckType {
+			spectator.HandleGameOver(over)
+			select {
+			case gameOver <- struct{}{}:
+			default:
+			}
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		panic(fmt.Errorf("Failed to subscribe to game over: %w", err))
+	}
+
+	// Incoming kicks
+	kicked := make(chan string, 1)
+	err = pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilDirect,
+		fmt.Sprintf("%s.%s", routing.KickPrefix, presence.Username),
+		"",
+		pubsub.QueueTypeTransient,
+		handlerKick(kicked),
+	)
+	if err != nil {
+		panic(fmt.Errorf("Failed to subscribe to kicks: %w", err))
+	}
+
+	err = publishPresence(conn, presence, routing.PresenceJoin)
+	if err != nil {
+		panic(fmt.Errorf("Failed to announce join: %w", err))
+	}
+	heartbeatDone := make(chan struct{})
+	go heartbeat(conn, presence, heartbeatDone)
+	defer func() {
+		close(heartbeatDone)
+		publishPresence(conn, presence, routing.PresenceLeave)
+	}()
+
+	gamelogic.PrintSpectatorHelp()
+	commands := make(chan []string)
+	go func() {
+		for {
+			commands <- gamelogic.GetInput()
+		}
+	}()
+
+	for {
+		var input []string
+		select {
+		case reason := <-kicked:
+			fmt.Println()
+			fmt.Printf("You have been kicked from the server: %s\n", reason)
+			return
+		case <-gameOver:
+			return
+		case input = <-commands:
+		}
+
+		if len(input) == 0 {
+			continue
+		}
+
+		switch input[0] {
+		case "status":
+			spectator.PrintSummary()
+		case "map":
+			spectator.CommandMap()
+		case "help":
+			gamelogic.PrintSpectatorHelp()
+		case "quit":
+			fmt.Println("Stopped watching.")
+			return
+		case "spawn", "move", "propose", "accept", "break", "spam":
+			fmt.Printf("Spectators can not %s.\n", input[0])
+		default:
+			fmt.Printf("Unknown command: %q\n", input[0])
+		}
+	}
+}
+
+// subscribeSpectator subscribes to everything a spectator watches. Nothing
+// is ever published back to the game.
+func subscribeSpectator(conn pubsub.Broker, spectator *gamelogic.Spectator, gameID string) error {
+	err := pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.GameInfoPrefix, gameID, spectator.Username),
+		"",
+		pubsub.QueueTypeTransient,
+		func(gi gamelogic.GameInfo) pubsub.AckType {
+			defer fmt.Print("> ")
+			err := spectator.HandleGameInfo(gi)
+			if err != nil {
+				fmt.Println(err)
+				return pubsub.AckTypeNackDiscard
+			}
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to game info: %w", err)
+	}
+
+	// Every move in the game, rather than only those a player could see.
+	err = pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.ArmyMovesPrefix, gameID),
+		"",
+		pubsub.QueueTypeTransient,
+		func(move gamelogic.ArmyMove) pubsub.AckType {
+			defer fmt.Print("> ")
+			spectator.HandleMove(move)
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to army moves: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.WarRecognitionsPrefix, gameID, "*"),
+		"",
+		pubsub.QueueTypeTransient,
+		func(rw gamelogic.RecognitionOfWar) pubsub.AckType {
+			spectator.HandleWar(rw)
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to wars: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.WarResultsPrefix, gameID, "*"),
+		"",
+		pubsub.QueueTypeTransient,
+		func(wr gamelogic.WarResult) pubsub.AckType {
+			defer fmt.Print("> ")
+			spectator.HandleWarResult(wr)
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to war results: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilDirect,
+		routing.GameKey(routing.PauseKey, gameID),
+		"",
+		pubsub.QueueTypeTransient,
+		func(ps routing.PlayingState) pubsub.AckType {
+			defer fmt.Print("> ")
+			spectator.HandlePause(ps)
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to server pause state: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.TurnKey, gameID),
+		"",
+		pubsub.QueueTypeTransient,
+		func(turn gamelogic.Turn) pubsub.AckType {
+			defer fmt.Print("> ")
+			spectator.HandleTurn(turn)
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to turns: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerGob(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.GameLogSlug, gameID, "*"),
+		"",
+		pubsub.QueueTypeTransient,
+		func(gl routing.GameLog) pubsub.AckType {
+			defer fmt.Print("> ")
+			fmt.Println()
+			fmt.Printf("[%s] %s: %s\n", gl.CurrentTime.Format(time.Kitchen), gl.Username, gl.Message)
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to game logs: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilDirect,
+		routing.AnnouncementKey,
+		"",
+		pubsub.QueueTypeTransient,
+		handlerAnnouncement(),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to announcements: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// credentials maps each username allowed to connect to the SHA-256 hash of
+// their token.
+type credentials map[string][sha256.Size]byte
+
+// loadCredentials reads a credentials file: one "<username>:<hex SHA-256 of
+// token>" per line, with blank lines and lines starting with '#' ignored.
+func loadCredentials(path string) (credentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open credentials: %w", err)
+	}
+	defer f.Close()
+
+	creds := credentials{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(text, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("%s:%d: expected <username>:<token hash>", path, line)
+		}
+		sum, err := hex.DecodeString(hash)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: token hash must be a hex SHA-256", path, line)
+		}
+		creds[username] = [sha256.Size]byte(sum)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read credentials: %w", err)
+	}
+	if len(creds) == 0 {
+		return nil, fmt.Errorf("%s has no credentials", path)
+	}
+	return creds, nil
+}
+
+// check reports whether token is username's.
+func (c credentials) check(username string, token string) bool {
+	want, ok := c[username]
+	got := sha256.Sum256([]byte(token))
+	// Compare even for unknown users, so timing doesn't reveal who exists.
+	return subtle.ConstantTimeCompare(got[:], want[:]) == 1 && ok
+}
+
+// checkOrigin allows WebSocket connections from the given origins only. With
+// none, only pages served from the gateway's own host may connect.
+func checkOrigin(origins []string) func(*http.Request) bool {
+	if len(origins) == 0 {
+		// The upgrader's default same-host check.
+		return nil
+	}
+	return func(r *http.Request) bool {
+		return slices.Contains(origins, r.Header.Get("Origin"))
+	}
+}
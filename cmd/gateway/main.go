@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/joho/godotenv"
+)
+
+const logfilepath string = "gateway.log"
+
+const defaultAddr = ":8080"
+
+var logger log.Logger
+
+func main() {
+	godotenv.Load(".env")
+
+	connstr, found := os.LookupEnv("RABBITMQ_CONN_STRING")
+	if !found {
+		panic("AMQP connection string not found!")
+	}
+
+	addr, found := os.LookupEnv("GATEWAY_ADDR")
+	if !found {
+		addr = defaultAddr
+	}
+
+	logfile, err := os.OpenFile(logfilepath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		panic(fmt.Errorf("Failed to open logfile %q: %w", logfilepath, err))
+	}
+	logger = *log.New(logfile, "", log.Ldate|log.Ltime)
+
+	credsPath, found := os.LookupEnv("GATEWAY_CREDENTIALS")
+	if !found {
+		panic("GATEWAY_CREDENTIALS not set! Browsers can't log in without credentials.")
+	}
+	creds, err := loadCredentials(credsPath)
+	if err != nil {
+		panic(err)
+	}
+
+	origins := []string{}
+	if value, found := os.LookupEnv("GATEWAY_ORIGINS"); found {
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				origins = append(origins, origin)
+			}
+		}
+	}
+
+	gw := &gateway{
+		connstr:     connstr,
+		credentials: creds,
+		sessions:    map[string]struct{}{},
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin:     checkOrigin(origins),
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ws", gw.handleWebSocket)
+
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	fmt.Printf("Peril gateway listening on %s\n", addr)
+	err = httpServer.ListenAndServe()
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/unappendixed/bootdevpubsub/internal/gamelogic"
+	"github.com/unappendixed/bootdevpubsub/internal/pubsub"
+	"github.com/unappendixed/bootdevpubsub/internal/routing"
+)
+
+// Sockets must authenticate within this long of connecting.
+const authTimeout = 30 * time.Second
+
+type messageType string
+
+const (
+	messageAuth             messageType = "auth"
+	messageWelcome          messageType = "welcome"
+	messageError            messageType = "error"
+	messageKicked           messageType = "kicked"
+	messageArmyMove         messageType = "army_move"
+	messageRecognitionOfWar messageType = "recognition_of_war"
+	messageWarResult        messageType = "war_result"
+	messagePlayingState     messageType = "playing_state"
+	messageGameLog          messageType = "game_log"
+	messageIntent           messageType = "intent"
+	messageStateUpdate      messageType = "state_update"
+	messageGameInfo         messageType = "game_info"
+	messageTurn             messageType = "turn"
+	messageGameOver         messageType = "game_over"
+	messageDiplomacy        messageType = "diplomacy"
+)
+
+// envelope is the frame exchanged with browsers in both directions.
+type envelope struct {
+	Type    messageType     `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type authRequest struct {
+	Username string `json:"username"`
+	// GameID is the game to join.
+	GameID string `json:"game"`
+	Token  string `json:"token"`
+}
+
+type welcome struct {
+	Username string `json:"username"`
+	GameID   string `json:"game"`
+}
+
+type errorMessage struct {
+	Error string `json:"error"`
+}
+
+type gateway struct {
+	connstr     string
+	credentials credentials
+	upgrader    websocket.Upgrader
+	sessions    map[string]struct{}
+	mu          sync.Mutex
+}
+
+// claim reserves username for a new session. Only one socket per username is
+// allowed on a gateway.
+func (gw *gateway) claim(username string) bool {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	if _, ok := gw.sessions[username]; ok {
+		return false
+	}
+	gw.sessions[username] = struct{}{}
+	return true
+}
+
+func (gw *gateway) release(username string) {
+	gw.mu.Lock()
+	defer gw.mu.Unlock()
+	delete(gw.sessions, username)
+}
+
+func (gw *gateway) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := gw.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Printf("Failed to upgrade connection: %v\n", err)
+		return
+	}
+	defer ws.Close()
+
+	username, gameID, err := gw.authenticate(ws)
+	if err != nil {
+		writeError(ws, err)
+		return
+	}
+	if !gw.claim(username) {
+		writeError(ws, fmt.Errorf("%s is already connected", username))
+		return
+	}
+	defer gw.release(username)
+
+	// Every session gets its own broker connection so that closing the
+	// socket tears down its queues and consumers with it.
+	conn, err := pubsub.Dial(gw.connstr)
+	if err != nil {
+		logger.Printf("Failed to connect to message broker: %v\n", err)
+		writeError(ws, errors.New("the game server is unavailable"))
+		return
+	}
+	defer conn.Close()
+
+	s, err := newSession(username, gameID, ws, conn)
+	if err != nil {
+		logger.Printf("Failed to start session for %s: %v\n", username, err)
+		writeError(ws, errors.New("the game server is unavailable"))
+		return
+	}
+	logger.Printf("%s connected from %s to game %s\n", username, r.RemoteAddr, gameID)
+	s.run()
+	logger.Printf("%s disconnected\n", username)
+}
+
+// authenticate reads the auth message, returning the username and game ID.
+func (gw *gateway) authenticate(ws *websocket.Conn) (string, string, error) {
+	ws.SetReadDeadline(time.Now().Add(authTimeout))
+	defer ws.SetReadDeadline(time.Time{})
+
+	var env envelope
+	err := ws.ReadJSON(&env)
+	if err != nil {
+		return "", "", errors.New("expected an auth message")
+	}
+	if env.Type != messageAuth {
+		return "", "", fmt.Errorf("expected an auth message, got %q", env.Type)
+	}
+
+	var auth authRequest
+	err = json.Unmarshal(env.Payload, &auth)
+	if err != nil {
+		return "", "", errors.New("malformed auth message")
+	}
+	if auth.Username == "" || strings.ContainsAny(auth.Username, ".*# \t") {
+		return "", "", errors.New("username must be a single word without '.', '*' or '#'")
+	}
+	if !gw.credentials.check(auth.Username, auth.Token) {
+		return "", "", errors.New("invalid username or token")
+	}
+	if auth.GameID == "" || strings.ContainsAny(auth.GameID, ".*# \t") {
+		return "", "", errors.New("game must be a single word without '.', '*' or '#'")
+	}
+	return auth.Username, auth.GameID, nil
+}
+
+func writeError(ws *websocket.Conn, err error) {
+	env, _ := newEnvelope(messageError, errorMessage{Error: err.Error()})
+	ws.WriteJSON(env)
+}
+
+func newEnvelope(kind messageType, val any) (envelope, error) {
+	payload, err := json.Marshal(val)
+	if err != nil {
+		return envelope{}, err
+	}
+	return envelope{Type: kind, Payload: payload}, nil
+}
+
+// session bridges a single authenticated socket and the peril exchanges.
+type session struct {
+	username string
+	gameID   string
+	ws       *websocket.Conn
+	conn     pubsub.Broker
+	outgoing chan envelope
+	done     chan struct{}
+	kicked   chan string
+}
+
+func newSession(username string, gameID string, ws *websocket.Conn, conn pubsub.Broker) (*session, error) {
+	s := &session{
+		username: username,
+		gameID:   gameID,
+		ws:       ws,
+		conn:     conn,
+		outgoing: make(chan envelope, 16),
+		done:     make(chan struct{}),
+		kicked:   make(chan string, 1),
+	}
+
+	err := s.subscribe()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// subscribe mirrors the queues and bindings used by the CLI client.
+func (s *session) subscribe() error {
+	// Pauses for the whole game and for this player alone share a queue.
+	pauseKey := routing.GameKey(routing.PauseKey, s.gameID, s.username)
+	for _, key := range []string{routing.GameKey(routing.PauseKey, s.gameID), pauseKey} {
+		err := pubsub.SubscribeBrokerJSON(
+			s.conn,
+			routing.ExchangePerilDirect,
+			key,
+			pauseKey,
+			pubsub.QueueTypeTransient,
+			forward[routing.PlayingState](s, messagePlayingState),
+		)
+		if err != nil {
+			return fmt.Errorf("Failed to subscribe to server pause state: %w", err)
+		}
+	}
+
+	err := pubsub.SubscribeBrokerJSON(
+		s.conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.GameInfoPrefix, s.gameID, s.username),
+		"",
+		pubsub.QueueTypeTransient,
+		forward[gamelogic.GameInfo](s, messageGameInfo),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to game info: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		s.conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.StatePrefix, s.gameID, s.username),
+		"",
+		pubsub.QueueTypeTransient,
+		forward[gamelogic.StateUpdate](s, messageStateUpdate),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to state updates: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		s.conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.TurnKey, s.gameID),
+		"",
+		pubsub.QueueTypeTransient,
+		forward[gamelogic.Turn](s, messageTurn),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to turns: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		s.conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.GameOverKey, s.gameID),
+		"",
+		pubsub.QueueTypeTransient,
+		forward[gamelogic.GameOver](s, messageGameOver),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to game over: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		s.conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.DiplomacyPrefix, s.gameID, s.username),
+		"",
+		pubsub.QueueTypeTransient,
+		forward[gamelogic.Diplomacy](s, messageDiplomacy),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to diplomacy: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		s.conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.ArmyMovesPrefix, s.gameID, s.username),
+		"",
+		pubsub.QueueTypeTransient,
+		forward[gamelogic.ArmyMove](s, messageArmyMove),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to army moves: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		s.conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.WarRecognitionsPrefix, s.gameID, "*"),
+		routing.GameKey(routing.WarRecognitionsPrefix, s.gameID, s.username),
+		pubsub.QueueTypeTransient,
+		forward[gamelogic.RecognitionOfWar](s, messageRecognitionOfWar),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to wars: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		s.conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.WarResultsPrefix, s.gameID, "*"),
+		"",
+		pubsub.QueueTypeTransient,
+		forward[gamelogic.WarResult](s, messageWarResult),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to war results: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerGob(
+		s.conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.GameLogSlug, s.gameID, "*"),
+		"",
+		pubsub.QueueTypeTransient,
+		forward[routing.GameLog](s, messageGameLog),
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to game logs: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		s.conn,
+		routing.ExchangePerilDirect,
+		fmt.Sprintf("%s.%s", routing.KickPrefix, s.username),
+		"",
+		pubsub.QueueTypeTransient,
+		func(k routing.Kick) pubsub.AckType {
+			select {
+			case s.kicked <- k.Reason:
+			default:
+			}
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to kicks: %w", err)
+	}
+
+	return nil
+}
+
+// forward returns a handler that relays every delivery to the socket.
+func forward[T any](s *session, kind messageType) func(T) pubsub.AckType {
+	return func(val T) pubsub.AckType {
+		env, err := newEnvelope(kind, val)
+		if err != nil {
+			logger.Printf("Failed to encode %s for %s: %v\n", kind, s.username, err)
+			return pubsub.AckTypeNackDiscard
+		}
+		select {
+		case s.outgoing <- env:
+			return pubsub.AckTypeAck
+		case <-s.done:
+			return pubsub.AckTypeNackRequeue
+		}
+	}
+}
+
+func (s *session) run() {
+	err := s.publishPresence(routing.PresenceJoin)
+	if err != nil {
+		logger.Printf("Failed to announce %s: %v\n", s.username, err)
+	}
+
+	// The welcome goes straight to the socket, ahead of anything the
+	// subscriptions have already queued: writeLoop isn't running yet, so
+	// queueing it could block on a full buffer forever.
+	env, _ := newEnvelope(messageWelcome, welcome{Username: s.username, GameID: s.gameID})
+	err = s.ws.WriteJSON(env)
+	if err == nil {
+		go s.writeLoop()
+		s.readLoop()
+	} else {
+		s.ws.Close()
+	}
+	close(s.done)
+
+	s.publishPresence(routing.PresenceLeave)
+}
+
+func (s *session) writeLoop() {
+	ticker := time.NewTicker(routing.PresenceHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case env := <-s.outgoing:
+			err := s.ws.WriteJSON(env)
+			if err != nil {
+				s.ws.Close()
+				return
+			}
+		case reason := <-s.kicked:
+			env, _ := newEnvelope(messageKicked, routing.Kick{Reason: reason})
+			s.ws.WriteJSON(env)
+			s.ws.Close()
+			return
+		case <-ticker.C:
+			err := s.publishPresence(routing.PresenceHeartbeat)
+			if err != nil {
+				logger.Printf("Failed to publish heartbeat for %s: %v\n", s.username, err)
+			}
+		}
+	}
+}
+
+func (s *session) readLoop() {
+	for {
+		var env envelope
+		err := s.ws.ReadJSON(&env)
+		if err != nil {
+			return
+		}
+
+		err = s.publish(env)
+		if err != nil {
+			errEnv, _ := newEnvelope(messageError, errorMessage{Error: err.Error()})
+			select {
+			case s.outgoing <- errEnv:
+			default:
+			}
+		}
+	}
+}
+
+// publish validates a message from the browser and sends it to the
+// exchange the CLI client would have used. Browsers act through intents just
+// like the CLI client; moves, wars and their results come from the server.
+func (s *session) publish(env envelope) error {
+	switch env.Type {
+	case messageIntent:
+		var intent gamelogic.Intent
+		err := json.Unmarshal(env.Payload, &intent)
+		if err != nil {
+			return fmt.Errorf("malformed %s: %w", env.Type, err)
+		}
+		intent.GameID = s.gameID
+		intent.Username = s.username
+		return pubsub.PublishBrokerJSON(
+			s.conn,
+			routing.ExchangePerilTopic,
+			routing.GameKey(routing.IntentsPrefix, s.gameID, s.username),
+			intent,
+		)
+	case messageGameLog:
+		var gl routing.GameLog
+		err := json.Unmarshal(env.Payload, &gl)
+		if err != nil {
+			return fmt.Errorf("malformed %s: %w", env.Type, err)
+		}
+		gl.Username = s.username
+		gl.CurrentTime = time.Now()
+		return pubsub.PublishBrokerGob(
+			s.conn,
+			routing.ExchangePerilTopic,
+			routing.GameKey(routing.GameLogSlug, s.gameID, s.username),
+			gl,
+		)
+	default:
+		return fmt.Errorf("unsupported message type %q", env.Type)
+	}
+}
+
+func (s *session) publishPresence(kind routing.PresenceKind) error {
+	return pubsub.PublishBrokerJSON(
+		s.conn,
+		routing.ExchangePerilTopic,
+		fmt.Sprintf("%s.%s", routing.PresencePrefix, s.username),
+		routing.PlayerPresence{
+			Username:    s.username,
+			GameID:      s.gameID,
+			Kind:        kind,
+			CurrentTime: time.Now(),
+		},
+	)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/unappendixed/bootdevpubsub/internal/gamelogic"
+)
+
+const defaultEventLogPath = "events.jsonl"
+
+// replay steps through a game recorded in the server's event log.
+//
+//	replay [event log] [game ID]
+func main() {
+	path := defaultEventLogPath
+	if len(os.Args) > 1 {
+		path = os.Args[1]
+	}
+	gameID := ""
+	if len(os.Args) > 2 {
+		gameID = os.Args[2]
+	}
+
+	events, err := gamelogic.ReadEvents(path)
+	if err != nil {
+		fmt.Printf("Failed to read %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	r, err := gamelogic.NewReplay(gamelogic.GameEvents(events, gameID))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	e, _ := r.Step()
+	fmt.Println(e)
+	fmt.Printf("%v events to replay.\n", r.Len())
+	gamelogic.PrintReplayHelp()
+
+	for {
+		input := gamelogic.GetInput()
+		if input == nil {
+			// End of input.
+			return
+		}
+		if len(input) == 0 {
+			continue
+		}
+
+		switch input[0] {
+		case "next", "back":
+			n := 1
+			if len(input) > 1 {
+				parsed, err := strconv.Atoi(input[1])
+				if err != nil || parsed <= 0 {
+					fmt.Println("Argument must be a positive integer")
+					continue
+				}
+				n = parsed
+			}
+			if input[0] == "back" {
+				r.Seek(r.Seq() - n)
+				fmt.Printf("Back to event #%v\n", r.Seq())
+				continue
+			}
+			for i := 0; i < n; i++ {
+				e, ok := r.Step()
+				if !ok {
+					fmt.Println("End of the game.")
+					break
+				}
+				fmt.Println(e)
+			}
+		case "seek":
+			if len(input) < 2 {
+				fmt.Println("Usage: seek <seq>")
+				continue
+			}
+			seq, err := strconv.Atoi(input[1])
+			if err != nil {
+				fmt.Println("Argument must be an integer")
+				continue
+			}
+			r.Seek(seq)
+			fmt.Printf("At event #%v\n", r.Seq())
+		case "players":
+			fmt.Println(r.Usernames())
+		case "status":
+			if len(input) < 2 {
+				fmt.Println("Usage: status <username>")
+				continue
+			}
+			gs, ok := r.Player(input[1])
+			if !ok {
+				fmt.Printf("%s has not joined yet\n", input[1])
+				continue
+			}
+			gs.CommandStatus()
+		case "standings":
+			players := []gamelogic.Player{}
+			for _, username := range r.Usernames() {
+				gs, _ := r.Player(username)
+				players = append(players, gs.GetPlayerSnap())
+			}
+			gamelogic.PrintStandings(gamelogic.Standings(r.Scenario(), players))
+		case "map":
+			gs := gamelogic.NewGameState("")
+			gs.SetScenario(r.Scenario())
+			gs.CommandMap()
+		case "help":
+			gamelogic.PrintReplayHelp()
+		case "quit":
+			return
+		default:
+			fmt.Printf("Unknown command: %q\n", input[0])
+		}
+	}
+}
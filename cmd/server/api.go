@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/unappendixed/bootdevpubsub/internal/routing"
+)
+
+const defaultRecentLogs = 50
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiPlayer struct {
+	Username  string    `json:"username"`
+	GameID    string    `json:"game"`
+	Spectator bool      `json:"spectator"`
+	JoinedAt  time.Time `json:"joined_at"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+type apiGameLog struct {
+	CurrentTime time.Time `json:"time"`
+	Username    string    `json:"username"`
+	Message     string    `json:"message"`
+}
+
+type apiPlayingState struct {
+	GameID   string `json:"game,omitempty"`
+	Username string `json:"username,omitempty"`
+	Paused   bool   `json:"paused"`
+}
+
+type apiGame struct {
+	GameID  string `json:"game"`
+	Map     string `json:"map"`
+	Players int    `json:"players"`
+	Over    bool   `json:"over"`
+}
+
+type apiPlayerStats struct {
+	Username     string `json:"username"`
+	Games        int    `json:"games"`
+	Wins         int    `json:"wins"`
+	WarsWon      int    `json:"wars_won"`
+	WarsLost     int    `json:"wars_lost"`
+	WarsDrawn    int    `json:"wars_drawn"`
+	UnitsSpawned int    `json:"units_spawned"`
+	UnitsLost    int    `json:"units_lost"`
+	Territories  int    `json:"territories"`
+}
+
+func (s *server) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /pause", s.authorized(s.handlePlayingState(true)))
+	mux.HandleFunc("POST /resume", s.authorized(s.handlePlayingState(false)))
+	mux.HandleFunc("GET /games", s.handleGames)
+	mux.HandleFunc("POST /games", s.authorized(s.handleCreateGame))
+	mux.HandleFunc("DELETE /games/{game}", s.authorized(s.handleCloseGame))
+	mux.HandleFunc("GET /players", s.handlePlayers)
+	mux.HandleFunc("GET /leaderboard", s.handleLeaderboard)
+	mux.HandleFunc("GET /logs", s.handleLogs)
+	mux.HandleFunc("GET /queues", s.handleQueues)
+	mux.HandleFunc("GET /health", s.handleHealth)
+	return mux
+}
+
+// serveAPI blocks serving the admin API on addr.
+func (s *server) serveAPI(addr string) error {
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           s.apiHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return httpServer.ListenAndServe()
+}
+
+// authorized only lets requests carrying the API token through to h, as
+// "Authorization: Bearer <token>".
+func (s *server) authorized(h http.HandlerFunc) http.HandlerFunc {
+	want := sha256.Sum256([]byte(s.apiToken))
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		got := sha256.Sum256([]byte(token))
+		if !ok || s.apiToken == "" || subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("a valid API token is required"))
+			return
+		}
+		h(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, val any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(val)
+	if err != nil {
+		logger.Printf("Failed to write API response: %v\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+// handlePlayingState pauses or resumes everyone in the game named by the game
+// query parameter, or only the player named by the username query parameter.
+// The game may be left out while only one is running.
+func (s *server) handlePlayingState(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g, err := s.lobby.find(r.URL.Query().Get("game"))
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		username := r.URL.Query().Get("username")
+		err = s.setPaused(g.id, username, paused)
+		if errors.Is(err, errNoSuchPlayer) {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, apiPlayingState{GameID: g.id, Username: username, Paused: paused})
+	}
+}
+
+func (s *server) handleGames(w http.ResponseWriter, r *http.Request) {
+	games := []apiGame{}
+	for _, gs := range s.lobby.list() {
+		games = append(games, newAPIGame(gs))
+	}
+	writeJSON(w, http.StatusOK, games)
+}
+
+// handleCreateGame starts a new game on the map named by the map query
+// parameter, looked up in MAPS_DIR like matchmaking does, or the configured map
+// if it is left out.
+func (s *server) handleCreateGame(w http.ResponseWriter, r *http.Request) {
+	mapFile, err := s.matches.mapFile(r.URL.Query().Get("map"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	g, err := s.lobby.create("", mapFile, uint64(time.Now().UnixNano()))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newAPIGame(g.summary()))
+}
+
+func (s *server) handleCloseGame(w http.ResponseWriter, r *http.Request) {
+	err := s.lobby.close(r.PathValue("game"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newAPIGame(gs routing.GameSummary) apiGame {
+	return apiGame{
+		GameID:  gs.GameID,
+		Map:     gs.Map,
+		Players: gs.Players,
+		Over:    gs.Over,
+	}
+}
+
+func (s *server) handlePlayers(w http.ResponseWriter, r *http.Request) {
+	players := []apiPlayer{}
+	for _, p := range s.players.snapshot() {
+		players = append(players, apiPlayer{
+			Username:  p.Username,
+			GameID:    p.GameID,
+			Spectator: p.Spectator,
+			JoinedAt:  p.JoinedAt,
+			LastSeen:  p.LastSeen,
+		})
+	}
+	writeJSON(w, http.StatusOK, players)
+}
+
+// handleLeaderboard lists every player's statistics, best first, or only the
+// top players if the limit query parameter is set.
+func (s *server) handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	board := s.lobby.stats.Leaderboard()
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "limit must be a positive integer"})
+			return
+		}
+		board = board[:min(n, len(board))]
+	}
+	leaderboard := []apiPlayerStats{}
+	for _, ps := range board {
+		leaderboard = append(leaderboard, apiPlayerStats{
+			Username:     ps.Username,
+			Games:        ps.Games,
+			Wins:         ps.Wins,
+			WarsWon:      ps.WarsWon,
+			WarsLost:     ps.WarsLost,
+			WarsDrawn:    ps.WarsDrawn,
+			UnitsSpawned: ps.UnitsSpawned,
+			UnitsLost:    ps.UnitsLost,
+			Territories:  ps.Territories,
+		})
+	}
+	writeJSON(w, http.StatusOK, leaderboard)
+}
+
+func (s *server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	n := defaultRecentLogs
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed <= 0 {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: "limit must be a positive integer"})
+			return
+		}
+		n = parsed
+	}
+
+	gamelogs, err := s.recentLogs(n)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	logs := []apiGameLog{}
+	for _, gl := range gamelogs {
+		logs = append(logs, apiGameLog{
+			CurrentTime: gl.CurrentTime,
+			Username:    gl.Username,
+			Message:     gl.Message,
+		})
+	}
+	writeJSON(w, http.StatusOK, logs)
+}
+
+func (s *server) handleQueues(w http.ResponseWriter, r *http.Request) {
+	depths, err := s.queueDepths()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, depths)
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	h := s.health()
+	status := http.StatusOK
+	if h.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, h)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/unappendixed/bootdevpubsub/internal/gamelogic"
+	"github.com/unappendixed/bootdevpubsub/internal/pubsub"
+	"github.com/unappendixed/bootdevpubsub/internal/routing"
+)
+
+// server holds everything the admin commands need. The console loop and the
+// HTTP API both call into it so the two stay in sync.
+type server struct {
+	conn    pubsub.Broker
+	players *playerRegistry
+	lobby   *lobby
+	matches *matchmaker
+	// apiToken is the bearer token the admin API requires for changes.
+	apiToken string
+}
+
+// inspectedQueues are the long-lived queues reported by queueDepths.
+var inspectedQueues = []string{
+	"game_logs",
+	routing.IntentsPrefix,
+	routing.LobbyPrefix,
+	routing.MatchmakingPrefix,
+	"peril_dlq",
+}
+
+type queueDepth struct {
+	Name      string `json:"name"`
+	Messages  int    `json:"messages"`
+	Consumers int    `json:"consumers"`
+	Error     string `json:"error,omitempty"`
+}
+
+type health struct {
+	Status   string `json:"status"`
+	RabbitMQ string `json:"rabbitmq"`
+	Players  int    `json:"players"`
+}
+
+// setPaused pauses or resumes a game for everyone, or for a single player if
+// username is not empty.
+func (s *server) setPaused(gameID string, username string, paused bool) error {
+	g, err := s.lobby.find(gameID)
+	if err != nil {
+		return err
+	}
+	key := routing.GameKey(routing.PauseKey, g.id)
+	if username != "" {
+		key = routing.GameKey(routing.PauseKey, g.id, username)
+	}
+	err = g.setPaused(username, paused)
+	if err != nil {
+		return err
+	}
+	return pubsub.PublishBrokerJSON(
+		s.conn,
+		routing.ExchangePerilDirect,
+		key,
+		routing.PlayingState{IsPaused: paused},
+	)
+}
+
+func (s *server) announce(message string) error {
+	return pubsub.PublishBrokerJSON(
+		s.conn,
+		routing.ExchangePerilDirect,
+		routing.AnnouncementKey,
+		routing.Announcement{
+			CurrentTime: time.Now(),
+			Message:     message,
+		},
+	)
+}
+
+func (s *server) kick(username string, reason string) error {
+	err := pubsub.PublishBrokerJSON(
+		s.conn,
+		routing.ExchangePerilDirect,
+		fmt.Sprintf("%s.%s", routing.KickPrefix, username),
+		routing.Kick{Reason: reason},
+	)
+	if err != nil {
+		return err
+	}
+	s.players.remove(username)
+	return nil
+}
+
+func (s *server) recentLogs(n int) ([]routing.GameLog, error) {
+	return gamelogic.ReadRecentLogs(n)
+}
+
+func (s *server) queueDepths() ([]queueDepth, error) {
+	inspector, ok := s.conn.(pubsub.QueueInspector)
+	if !ok {
+		return nil, errors.New("queue depths are only available over AMQP")
+	}
+	depths := []queueDepth{}
+	for _, name := range inspectedQueues {
+		queue, err := inspector.InspectQueue(name)
+		if err != nil {
+			depths = append(depths, queueDepth{Name: name, Error: err.Error()})
+			continue
+		}
+		depths = append(depths, queueDepth{
+			Name:      queue.Name,
+			Messages:  queue.Messages,
+			Consumers: queue.Consumers,
+		})
+	}
+	return depths, nil
+}
+
+func (s *server) health() health {
+	h := health{
+		Status:   "ok",
+		RabbitMQ: "connected",
+		Players:  len(s.players.snapshot()),
+	}
+	if s.conn.IsClosed() {
+		h.Status = "degraded"
+		h.RabbitMQ = "disconnected"
+	}
+	return h
+}
+
+func printRecentLogs(s *server, n int) {
+	logs, err := s.recentLogs(n)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(logs) == 0 {
+		fmt.Println("No game logs yet.")
+		return
+	}
+	for _, gl := range logs {
+		fmt.Printf("%s %s: %s\n", gl.CurrentTime.Format(time.RFC3339), gl.Username, gl.Message)
+	}
+}
+
+func printQueueDepths(s *server) {
+	depths, err := s.queueDepths()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, qd := range depths {
+		if qd.Error != "" {
+			fmt.Printf("* %s: %s\n", qd.Name, qd.Error)
+			continue
+		}
+		fmt.Printf("* %s: %d message(s), %d consumer(s)\n", qd.Name, qd.Messages, qd.Consumers)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/unappendixed/bootdevpubsub/internal/gamelogic"
+	"github.com/unappendixed/bootdevpubsub/internal/pubsub"
+	"github.com/unappendixed/bootdevpubsub/internal/routing"
+)
+
+// handleDiplomacy proposes, accepts or breaks an alliance on behalf of
+// intent.Username, whom the lobby has checked sent it. Diplomacy takes effect
+// straight away, even in turn-based games.
+func (g *game) handleDiplomacy(intent gamelogic.Intent) pubsub.AckType {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.seated(intent.Username) {
+		logger.Printf("Discarding %s intent from %s, who has no seat in game %s\n", intent.Kind, intent.Username, g.id)
+		return pubsub.AckTypeNackDiscard
+	}
+	defer g.persist()
+	gs := g.player(intent.Username)
+
+	if g.over {
+		g.publishState(gs, "", errors.New("the game is over"))
+		return pubsub.AckTypeAck
+	}
+	other, ok := g.states[intent.Player]
+	if !ok || intent.Player == intent.Username {
+		g.publishState(gs, "", fmt.Errorf("error: %s is not in the game", intent.Player))
+		return pubsub.AckTypeAck
+	}
+
+	var err error
+	switch intent.Kind {
+	case gamelogic.IntentPropose:
+		err = g.propose(gs, other)
+	case gamelogic.IntentAccept:
+		err = g.accept(gs, other)
+	case gamelogic.IntentBreak:
+		err = g.breakAlliance(gs, other)
+	}
+	if err != nil {
+		g.publishState(gs, "", err)
+		return pubsub.AckTypeAck
+	}
+
+	g.publishDiplomacy(gamelogic.Diplomacy{
+		Kind: intent.Kind,
+		From: intent.Username,
+		To:   intent.Player,
+	})
+	if intent.Kind == gamelogic.IntentBreak {
+		// Former allies sharing a location go to war straight away.
+		g.resolveWars(gs)
+		g.checkVictory()
+	}
+	return pubsub.AckTypeAck
+}
+
+func (g *game) propose(gs *gamelogic.GameState, other *gamelogic.GameState) error {
+	if gs.IsAlly(other.GetUsername()) {
+		return fmt.Errorf("error: you are already allied with %s", other.GetUsername())
+	}
+	proposed, ok := g.proposals[gs.GetUsername()]
+	if !ok {
+		proposed = map[string]struct{}{}
+		g.proposals[gs.GetUsername()] = proposed
+	}
+	proposed[other.GetUsername()] = struct{}{}
+	g.publishState(gs, fmt.Sprintf("You proposed an alliance to %s", other.GetUsername()), nil)
+	return nil
+}
+
+func (g *game) accept(gs *gamelogic.GameState, proposer *gamelogic.GameState) error {
+	if _, ok := g.proposals[proposer.GetUsername()][gs.GetUsername()]; !ok {
+		return fmt.Errorf("error: %s has not proposed an alliance to you", proposer.GetUsername())
+	}
+	delete(g.proposals[proposer.GetUsername()], gs.GetUsername())
+	delete(g.proposals[gs.GetUsername()], proposer.GetUsername())
+
+	gs.AddAlly(proposer.GetUsername())
+	proposer.AddAlly(gs.GetUsername())
+	g.record(gamelogic.Event{
+		Kind:     gamelogic.EventAlliance,
+		Username: gs.GetUsername(),
+		Ally:     proposer.GetUsername(),
+	})
+	g.publishState(gs, fmt.Sprintf("You are now allied with %s", proposer.GetUsername()), nil)
+	g.publishState(proposer, "", nil)
+	return nil
+}
+
+func (g *game) breakAlliance(gs *gamelogic.GameState, ally *gamelogic.GameState) error {
+	if !gs.IsAlly(ally.GetUsername()) {
+		return fmt.Errorf("error: you are not allied with %s", ally.GetUsername())
+	}
+	gs.RemoveAlly(ally.GetUsername())
+	ally.RemoveAlly(gs.GetUsername())
+	g.record(gamelogic.Event{
+		Kind:     gamelogic.EventBreak,
+		Username: gs.GetUsername(),
+		Ally:     ally.GetUsername(),
+	})
+	g.publishState(gs, fmt.Sprintf("You broke off your alliance with %s", ally.GetUsername()), nil)
+	g.publishState(ally, "", nil)
+	return nil
+}
+
+func (g *game) publishDiplomacy(d gamelogic.Diplomacy) {
+	err := pubsub.PublishBrokerJSON(
+		g.conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.DiplomacyPrefix, g.id, d.To),
+		d,
+	)
+	if err != nil {
+		logger.Printf("Failed to publish diplomacy: %v\n", err)
+	}
+}
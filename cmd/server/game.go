@@ -0,0 +1,738 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unappendixed/bootdevpubsub/internal/gamelogic"
+	"github.com/unappendixed/bootdevpubsub/internal/pubsub"
+	"github.com/unappendixed/bootdevpubsub/internal/routing"
+)
+
+var errNoSuchPlayer = errors.New("no such player")
+
+// game is the server's authoritative copy of every player's state. Clients
+// only send intents; the game validates and applies them, resolves any wars
+// they cause and publishes the results.
+//
+// In real time, intents are applied as they arrive. Once startTurns has been
+// called they are queued as orders instead and all applied together at the
+// end of each turn.
+type game struct {
+	id           string
+	states       map[string]*gamelogic.GameState
+	scenario     *gamelogic.Scenario
+	seed         uint64
+	paused       bool
+	over         bool
+	turnInterval time.Duration
+	turn         gamelogic.Turn
+	orders       []gamelogic.Intent
+	// deadline is when the time limit runs out, if the game has one, and
+	// timeUsed how much of it had passed before the game was restored.
+	deadline time.Time
+	timeUsed time.Duration
+	// proposals holds every alliance proposed but not yet accepted, keyed
+	// by proposer and then by the player they proposed to.
+	proposals map[string]map[string]struct{}
+	// seats, if set, are the only players allowed to join, e.g. those
+	// matched into the game.
+	seats map[string]struct{}
+	// savePath is where the game is saved after every change, if set.
+	savePath string
+	// savePending is set while a change is waiting to be saved.
+	savePending bool
+	// saveMu keeps saves in order. Take it before g.mu, never after.
+	saveMu *sync.Mutex
+	// events records every change to the game, if set.
+	events *gamelogic.EventLog
+	// stats counts the game towards each player's statistics, if set.
+	stats *gamelogic.Stats
+	// spectatorDelay is how long moves are held back from spectators.
+	spectatorDelay time.Duration
+	// done is closed when the game is closed, stopping its clocks.
+	done chan struct{}
+	conn pubsub.Broker
+	mu   *sync.Mutex
+}
+
+func newGame(conn pubsub.Broker, id string, scenario *gamelogic.Scenario, seed uint64) *game {
+	return &game{
+		id:        id,
+		states:    map[string]*gamelogic.GameState{},
+		scenario:  scenario,
+		seed:      seed,
+		proposals: map[string]map[string]struct{}{},
+		done:      make(chan struct{}),
+		saveMu:    &sync.Mutex{},
+		conn:      conn,
+		mu:        &sync.Mutex{},
+	}
+}
+
+// player returns the state for username, creating it on first use. The
+// caller must hold g.mu.
+func (g *game) player(username string) *gamelogic.GameState {
+	gs, ok := g.states[username]
+	if !ok {
+		gs = gamelogic.NewGameState(username)
+		gs.SetGameID(g.id)
+		gs.SetScenario(g.scenario)
+		gs.SetSeed(g.seed)
+		gs.SetTreasury(g.scenario.StartingTreasury)
+		gs.SetPaused(g.paused)
+		g.states[username] = gs
+		g.record(gamelogic.Event{
+			Kind:     gamelogic.EventJoin,
+			Username: username,
+			Gold:     gs.GetTreasury(),
+		})
+	}
+	return gs
+}
+
+// reserve keeps the game for the given players. Nobody else may join it
+// unless players is empty.
+func (g *game) reserve(players []string) {
+	if len(players) == 0 {
+		return
+	}
+	g.seats = map[string]struct{}{}
+	for _, username := range players {
+		g.seats[username] = struct{}{}
+	}
+}
+
+// seated reports whether username may play in the game. The caller must hold
+// g.mu.
+func (g *game) seated(username string) bool {
+	if g.seats == nil {
+		return true
+	}
+	_, ok := g.seats[username]
+	return ok
+}
+
+// start records the start of a new game.
+func (g *game) start() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.record(gamelogic.Event{
+		Kind:     gamelogic.EventStart,
+		Scenario: g.scenario,
+		Seed:     g.seed,
+	})
+}
+
+// record appends an event to the game's event log and counts it towards the
+// players' statistics, if the game has them. The caller must hold g.mu.
+func (g *game) record(e gamelogic.Event) {
+	e.GameID = g.id
+	if g.stats != nil {
+		g.stats.Record(e)
+	}
+	if g.events == nil {
+		return
+	}
+	_, err := g.events.Append(e)
+	if err != nil {
+		logger.Printf("Failed to record %s event: %v\n", e.Kind, err)
+	}
+}
+
+// usernames returns every known player in sorted order. The caller must
+// hold g.mu.
+func (g *game) usernames() []string {
+	usernames := []string{}
+	for username := range g.states {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+func (g *game) hasPlayer(username string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.states[username]
+	return ok
+}
+
+// setPaused pauses or resumes the game for everyone, or for a single player
+// if username is set. The player must already be in the game.
+func (g *game) setPaused(username string, paused bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	kind := gamelogic.EventResume
+	if paused {
+		kind = gamelogic.EventPause
+	}
+	if username != "" {
+		gs, ok := g.states[username]
+		if !ok {
+			return fmt.Errorf("%w: %s is not in game %s", errNoSuchPlayer, username, g.id)
+		}
+		defer g.persist()
+		gs.SetPaused(paused)
+		g.record(gamelogic.Event{Kind: kind, Username: username})
+		return nil
+	}
+	defer g.persist()
+	g.record(gamelogic.Event{Kind: kind})
+	g.paused = paused
+	for _, gs := range g.states {
+		gs.SetPaused(paused)
+	}
+	if !paused && g.turnInterval > 0 {
+		// Give players a full turn after resuming.
+		g.turn.Deadline = time.Now().Add(g.turnInterval)
+		g.publishTurn()
+	}
+	return nil
+}
+
+// welcome tells a joining player which game they are in and, if they have
+// played before, sends their current state so they pick up where they left
+// off.
+func (g *game) welcome(username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	err := pubsub.PublishBrokerJSON(
+		g.conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.GameInfoPrefix, g.id, username),
+		g.info(),
+	)
+	if err != nil {
+		logger.Printf("Failed to publish game info for %s: %v\n", username, err)
+	}
+
+	gs, ok := g.states[username]
+	if !ok {
+		return
+	}
+	g.publishState(gs, "", nil)
+}
+
+// info is what a joining player needs to know about the game. The caller
+// must hold g.mu.
+func (g *game) info() gamelogic.GameInfo {
+	gi := gamelogic.GameInfo{GameID: g.id, Scenario: g.scenario, Seed: g.seed}
+	if g.turnInterval > 0 {
+		turn := g.turn
+		gi.Turn = &turn
+	}
+	return gi
+}
+
+// handleIntent carries out an intent, or queues it as an order in turn-based
+// games. The lobby has already checked that intent.Username sent it.
+func (g *game) handleIntent(intent gamelogic.Intent) pubsub.AckType {
+	if intent.Username == "" {
+		return pubsub.AckTypeNackDiscard
+	}
+	switch intent.Kind {
+	case gamelogic.IntentSpawn, gamelogic.IntentMove:
+	case gamelogic.IntentPropose, gamelogic.IntentAccept, gamelogic.IntentBreak:
+		return g.handleDiplomacy(intent)
+	default:
+		return pubsub.AckTypeNackDiscard
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.seated(intent.Username) {
+		logger.Printf("Discarding %s intent from %s, who has no seat in game %s\n", intent.Kind, intent.Username, g.id)
+		return pubsub.AckTypeNackDiscard
+	}
+	defer g.persist()
+	gs := g.player(intent.Username)
+
+	if g.over {
+		g.publishState(gs, "", errors.New("the game is over"))
+		return pubsub.AckTypeAck
+	}
+	if g.turnInterval > 0 {
+		if gs.IsPaused() {
+			g.publishState(gs, "", errors.New("the game is paused, you can not give orders"))
+			return pubsub.AckTypeAck
+		}
+		g.orders = append(g.orders, intent)
+		g.publishState(gs, fmt.Sprintf("Your %s order will be carried out at the end of turn %v", intent.Kind, g.turn.Number), nil)
+		return pubsub.AckTypeAck
+	}
+
+	moves := g.applyIntent(gs, intent)
+	if len(moves) > 0 {
+		g.publishMoves(moves)
+		g.resolveWars(gs)
+	}
+	g.checkVictory()
+	return pubsub.AckTypeAck
+}
+
+// applyIntent carries out a single intent, tells the player how it went and
+// returns any moves it caused. The caller must hold g.mu.
+func (g *game) applyIntent(gs *gamelogic.GameState, intent gamelogic.Intent) []gamelogic.ArmyMove {
+	switch intent.Kind {
+	case gamelogic.IntentSpawn:
+		treasury := gs.GetTreasury()
+		unit, err := gs.ApplySpawn(intent)
+		if err != nil {
+			g.publishState(gs, "", err)
+			return nil
+		}
+		g.record(gamelogic.Event{
+			Kind:     gamelogic.EventSpawn,
+			Username: gs.GetUsername(),
+			Unit:     &unit,
+			Gold:     treasury - gs.GetTreasury(),
+		})
+		g.publishState(gs, fmt.Sprintf("Spawned a(n) %s in %s with id %v", unit.Rank, unit.Location, unit.ID), nil)
+	case gamelogic.IntentMove:
+		moves, err := gs.ApplyMove(intent)
+		if err != nil {
+			g.publishState(gs, "", err)
+			return nil
+		}
+		g.publishState(gs, fmt.Sprintf("Ordered %v units to %s", len(intent.UnitIDs), intent.Location), nil)
+		return moves
+	}
+	return nil
+}
+
+// startTurns switches the game to turns of the given length and announces
+// the first one.
+func (g *game) startTurns(interval time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.turnInterval = interval
+	// A restored game carries on from the turn it was saved in.
+	g.turn = gamelogic.Turn{Number: max(g.turn.Number, 1), Deadline: time.Now().Add(interval)}
+	g.publishTurn()
+}
+
+// run starts the game's clocks: its time limit, and either turns or real-time
+// travel and income. They stop when the game is closed.
+func (g *game) run(settings gameSettings) {
+	if limit := g.scenario.TimeLimit(); limit > 0 {
+		g.mu.Lock()
+		left := limit - g.timeUsed
+		g.deadline = time.Now().Add(left)
+		g.mu.Unlock()
+		timer := time.AfterFunc(left, g.timeUp)
+		go func() {
+			<-g.done
+			timer.Stop()
+		}()
+	}
+
+	if settings.turnInterval > 0 {
+		// Travelling units move and income is paid at the end of each turn
+		// instead.
+		g.startTurns(settings.turnInterval)
+		go g.runTurns()
+		return
+	}
+	if g.scenario.Travel {
+		go every(settings.travelInterval, g.done, g.advanceTravel)
+	}
+	go every(settings.incomeInterval, g.done, g.payIncome)
+}
+
+// close ends the game if it is still being played and stops its clocks. A
+// closed game is no longer saved.
+func (g *game) close() {
+	// Wait for any save in progress, so it can't write the game back after
+	// the lobby deletes its file.
+	g.saveMu.Lock()
+	defer g.saveMu.Unlock()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.over {
+		g.end(gamelogic.GameOver{Reason: "the game was closed by the server"})
+	}
+	g.savePath = ""
+	close(g.done)
+}
+
+// summary describes the game for the lobby.
+func (g *game) summary() routing.GameSummary {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return routing.GameSummary{
+		GameID:  g.id,
+		Map:     g.scenario.Name,
+		Players: len(g.states),
+		Over:    g.over,
+	}
+}
+
+// runTurns ends each turn once its deadline has passed, until the game is
+// over or closed.
+func (g *game) runTurns() {
+	for {
+		g.mu.Lock()
+		wait := time.Until(g.turn.Deadline)
+		over := g.over
+		g.mu.Unlock()
+		if over {
+			return
+		}
+		if wait > 0 {
+			select {
+			case <-g.done:
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+		g.endTurn()
+	}
+}
+
+// endTurn resolves the turn and starts the next one. Travelling units take
+// their next step, then every order is carried out, ordered by player so the
+// result doesn't depend on when orders arrived. Wars are only fought once
+// everyone has moved, and income is paid last. While the game is paused, the
+// turn is put off instead.
+func (g *game) endTurn() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	defer g.persist()
+	if g.over {
+		return
+	}
+	if g.paused {
+		g.turn.Deadline = time.Now().Add(g.turnInterval)
+		return
+	}
+
+	orders := g.orders
+	g.orders = nil
+	slices.SortStableFunc(orders, func(a, b gamelogic.Intent) int {
+		return strings.Compare(a.Username, b.Username)
+	})
+
+	moves := []gamelogic.ArmyMove{}
+	for _, username := range g.usernames() {
+		gs := g.states[username]
+		travelled := gs.AdvanceTravel()
+		if len(travelled) > 0 {
+			g.publishState(gs, "", nil)
+			moves = append(moves, travelled...)
+		}
+	}
+	for _, intent := range orders {
+		moves = append(moves, g.applyIntent(g.player(intent.Username), intent)...)
+	}
+	g.publishMoves(moves)
+	for _, username := range g.usernames() {
+		g.resolveWars(g.states[username])
+	}
+	g.collectIncome()
+	if g.checkVictory() {
+		return
+	}
+
+	g.turn = gamelogic.Turn{
+		Number:   g.turn.Number + 1,
+		Deadline: time.Now().Add(g.turnInterval),
+	}
+	g.publishTurn()
+}
+
+// advanceTravel moves every travelling unit one step and fights any wars
+// that causes.
+func (g *game) advanceTravel() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	defer g.persist()
+	if g.paused || g.over {
+		return
+	}
+	for _, username := range g.usernames() {
+		gs := g.states[username]
+		moves := gs.AdvanceTravel()
+		if len(moves) == 0 {
+			continue
+		}
+		g.publishState(gs, "", nil)
+		g.publishMoves(moves)
+		g.resolveWars(gs)
+	}
+	g.checkVictory()
+}
+
+// payIncome pays every player for their territories, unless the game is
+// paused. It is the real-time counterpart of the income paid each turn.
+func (g *game) payIncome() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	defer g.persist()
+	if g.paused || g.over {
+		return
+	}
+	g.collectIncome()
+}
+
+// collectIncome pays every player for their territories. The caller must
+// hold g.mu.
+func (g *game) collectIncome() {
+	for _, username := range g.usernames() {
+		gs := g.states[username]
+		if gs.IsPaused() {
+			continue
+		}
+		income := gs.CollectIncome()
+		if income > 0 {
+			g.record(gamelogic.Event{
+				Kind:     gamelogic.EventIncome,
+				Username: username,
+				Gold:     income,
+			})
+			g.publishState(gs, fmt.Sprintf("Your territories earned %v gold", income), nil)
+		}
+	}
+}
+
+// players returns a snapshot of every player in sorted order. The caller
+// must hold g.mu.
+func (g *game) players() []gamelogic.Player {
+	players := []gamelogic.Player{}
+	for _, username := range g.usernames() {
+		players = append(players, g.states[username].GetPlayerSnap())
+	}
+	return players
+}
+
+func (g *game) standings() []gamelogic.Standing {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return gamelogic.Standings(g.scenario, g.players())
+}
+
+// checkVictory ends the game if anyone has won it, and reports whether the
+// game is over. The caller must hold g.mu.
+func (g *game) checkVictory() bool {
+	if g.over {
+		return true
+	}
+	over, ok := gamelogic.CheckVictory(g.scenario, g.players())
+	if !ok {
+		return false
+	}
+	g.end(over)
+	return true
+}
+
+// timeUp ends the game when its time limit runs out.
+func (g *game) timeUp() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	defer g.persist()
+	if g.over {
+		return
+	}
+	g.end(gamelogic.TimeUp(g.scenario, g.players()))
+}
+
+// end stops the game and tells everyone how it ended. The caller must hold
+// g.mu.
+func (g *game) end(over gamelogic.GameOver) {
+	g.over = true
+	g.orders = nil
+	g.record(gamelogic.Event{Kind: gamelogic.EventGameOver, GameOver: &over})
+
+	fmt.Println()
+	if over.Winner == "" {
+		fmt.Printf("Game %s ended in a draw: %s\n", g.id, over.Reason)
+	} else {
+		fmt.Printf("%s won game %s: %s\n", over.Winner, g.id, over.Reason)
+	}
+	fmt.Print("> ")
+	logger.Printf("Game %s over: %s\n", g.id, over.Reason)
+
+	err := pubsub.PublishBrokerJSON(g.conn, routing.ExchangePerilTopic, routing.GameKey(routing.GameOverKey, g.id), over)
+	if err != nil {
+		logger.Printf("Failed to publish game over: %v\n", err)
+	}
+}
+
+func (g *game) publishTurn() {
+	err := pubsub.PublishBrokerJSON(g.conn, routing.ExchangePerilTopic, routing.GameKey(routing.TurnKey, g.id), g.turn)
+	if err != nil {
+		logger.Printf("Failed to publish turn %v: %v\n", g.turn.Number, err)
+	}
+}
+
+// publishMoves records the moves and sends each player what they can see of
+// them, under fog of war. Spectators see every move, but only once
+// g.spectatorDelay has passed, as nothing stops a player binding their feed.
+// The caller must hold g.mu.
+func (g *game) publishMoves(moves []gamelogic.ArmyMove) {
+	board := g.scenario.Board()
+	for _, move := range moves {
+		g.record(gamelogic.Event{
+			Kind:     gamelogic.EventMove,
+			Username: move.Player.Username,
+			Move: &gamelogic.ArmyMove{
+				Player:     gamelogic.Player{Username: move.Player.Username},
+				Units:      move.Units,
+				ToLocation: move.ToLocation,
+			},
+		})
+
+		g.publishSpectatorMove(move.Redacted())
+
+		for _, username := range g.usernames() {
+			if username == move.Player.Username {
+				continue
+			}
+			visible, ok := gamelogic.VisibleMove(move, g.states[username].GetPlayerSnap(), board)
+			if !ok {
+				continue
+			}
+			err := pubsub.PublishBrokerJSON(
+				g.conn,
+				routing.ExchangePerilTopic,
+				routing.GameKey(routing.ArmyMovesPrefix, g.id, username),
+				visible,
+			)
+			if err != nil {
+				logger.Printf("Failed to publish army move to %s: %v\n", username, err)
+			}
+		}
+	}
+}
+
+// publishSpectatorMove sends a move to the game's spectators after
+// g.spectatorDelay.
+func (g *game) publishSpectatorMove(move gamelogic.ArmyMove) {
+	publish := func() {
+		err := pubsub.PublishBrokerJSON(
+			g.conn,
+			routing.ExchangePerilTopic,
+			routing.GameKey(routing.ArmyMovesPrefix, g.id),
+			move,
+		)
+		if err != nil {
+			logger.Printf("Failed to publish army move to spectators: %v\n", err)
+		}
+	}
+	if g.spectatorDelay <= 0 {
+		publish()
+		return
+	}
+	time.AfterFunc(g.spectatorDelay, publish)
+}
+
+// resolveWars fights every war the attacker is now part of. Allies never
+// fight each other. The caller must hold g.mu.
+func (g *game) resolveWars(attacker *gamelogic.GameState) {
+	for _, username := range g.usernames() {
+		if username == attacker.GetUsername() || attacker.IsAlly(username) {
+			continue
+		}
+		defender := g.states[username]
+
+		for {
+			rw := gamelogic.RecognitionOfWar{
+				Attacker: attacker.GetPlayerSnap(),
+				Defender: defender.GetPlayerSnap(),
+			}
+			for _, ally := range rw.Defender.Allies {
+				allyState, ok := g.states[ally]
+				if !ok || ally == rw.Attacker.Username || attacker.IsAlly(ally) {
+					continue
+				}
+				rw.DefenderAllies = append(rw.DefenderAllies, allyState.GetPlayerSnap())
+			}
+			result, ok := gamelogic.ResolveWar(rw, g.scenario, g.seed, attacker.NextRandStream())
+			if !ok {
+				break
+			}
+			g.record(gamelogic.Event{Kind: gamelogic.EventWar, War: &rw})
+			g.record(gamelogic.Event{Kind: gamelogic.EventWarResult, WarResult: &result})
+			g.publishWar(rw, result)
+
+			attacker.ApplyWarResult(result)
+			defender.ApplyWarResult(result)
+			g.publishState(attacker, "", nil)
+			g.publishState(defender, "", nil)
+			for _, ally := range result.DefenderAllies {
+				g.states[ally].ApplyWarResult(result)
+				g.publishState(g.states[ally], "", nil)
+			}
+		}
+	}
+}
+
+func (g *game) publishWar(rw gamelogic.RecognitionOfWar, result gamelogic.WarResult) {
+	err := pubsub.PublishBrokerJSON(
+		g.conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.WarRecognitionsPrefix, g.id, result.Attacker),
+		rw.Redacted(result.Location),
+	)
+	if err != nil {
+		logger.Printf("Failed to publish war: %v\n", err)
+	}
+
+	err = pubsub.PublishBrokerJSON(
+		g.conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.WarResultsPrefix, g.id, result.Attacker),
+		result,
+	)
+	if err != nil {
+		logger.Printf("Failed to publish war result: %v\n", err)
+	}
+
+	gamelog := routing.GameLog{
+		CurrentTime: time.Now(),
+		Username:    result.Attacker,
+		Message:     fmt.Sprintf("%s won a war against %s", result.Winner, result.Loser),
+	}
+	if result.Draw {
+		gamelog.Message = fmt.Sprintf(
+			"A war between %s and %s resulted in a draw",
+			result.Attacker,
+			result.Defender,
+		)
+	}
+	err = pubsub.PublishBrokerGob(
+		g.conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.GameLogSlug, g.id, result.Attacker),
+		gamelog,
+	)
+	if err != nil {
+		logger.Printf("Failed to publish game log: %v\n", err)
+	}
+}
+
+func (g *game) publishState(gs *gamelogic.GameState, message string, rejected error) {
+	su := gamelogic.StateUpdate{
+		Player:  gs.GetPlayerSnap(),
+		Message: message,
+	}
+	if rejected != nil {
+		su.Error = rejected.Error()
+	}
+	err := pubsub.PublishBrokerJSON(
+		g.conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.StatePrefix, g.id, gs.GetUsername()),
+		su,
+	)
+	if err != nil {
+		logger.Printf("Failed to publish state for %s: %v\n", gs.GetUsername(), err)
+	}
+}
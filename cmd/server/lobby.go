@@ -0,0 +1,461 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unappendixed/bootdevpubsub/internal/gamelogic"
+	"github.com/unappendixed/bootdevpubsub/internal/pubsub"
+	"github.com/unappendixed/bootdevpubsub/internal/routing"
+)
+
+// gameSettings is the server's configuration, applied to every game it
+// creates.
+type gameSettings struct {
+	mapFile string
+	// travel enables travel on every map, not just those that allow it.
+	travel         bool
+	travelInterval time.Duration
+	combat         string
+	timeLimit      time.Duration
+	turnInterval   time.Duration
+	incomeInterval time.Duration
+	// spectatorDelay holds back the unfogged moves spectators see, so
+	// players gain nothing from reading them.
+	spectatorDelay time.Duration
+}
+
+// scenario loads mapFile, or the configured map if it is empty, and applies
+// the server's overrides to it.
+func (s gameSettings) scenario(mapFile string) (*gamelogic.Scenario, error) {
+	if mapFile == "" {
+		mapFile = s.mapFile
+	}
+	scenario := gamelogic.DefaultScenario()
+	if mapFile != "" {
+		var err error
+		scenario, err = gamelogic.LoadScenario(mapFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.travel {
+		scenario.EnableTravel()
+	}
+	if s.combat != "" {
+		err := scenario.SetCombat(s.combat)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.timeLimit > 0 {
+		scenario.SetTimeLimit(s.timeLimit)
+	}
+	return scenario, nil
+}
+
+// Limits on the games players can create from the lobby, so one client can't
+// fill the server with empty games.
+const (
+	maxGamesPerPlayer = 2
+	maxGames          = 50
+	// A game created from the lobby is closed if nobody has joined it by
+	// then.
+	idleGameTimeout = 10 * time.Minute
+)
+
+// lobby holds every game the server is running. Routing keys are scoped by
+// game ID, so any number of games can share the broker.
+type lobby struct {
+	games    map[string]*game
+	settings gameSettings
+	// saveDir is where each game is saved as <game ID>.json, if set.
+	saveDir string
+	// events is shared by every game; each event records its game's ID.
+	events *gamelogic.EventLog
+	// stats is shared by every game, so players build up a record across
+	// all of them.
+	stats *gamelogic.Stats
+	// creators maps each game created from the lobby to the player who
+	// asked for it.
+	creators map[string]string
+	conn     pubsub.Broker
+	mu       *sync.Mutex
+}
+
+func newLobby(conn pubsub.Broker, settings gameSettings, saveDir string, events *gamelogic.EventLog, stats *gamelogic.Stats) *lobby {
+	return &lobby{
+		games:    map[string]*game{},
+		settings: settings,
+		saveDir:  saveDir,
+		events:   events,
+		stats:    stats,
+		creators: map[string]string{},
+		conn:     conn,
+		mu:       &sync.Mutex{},
+	}
+}
+
+func (l *lobby) savePath(gameID string) string {
+	if l.saveDir == "" {
+		return ""
+	}
+	return filepath.Join(l.saveDir, gameID+".json")
+}
+
+// create starts a new game on mapFile, or the configured map if it is empty.
+// A new ID is picked if gameID is empty.
+func (l *lobby) create(gameID string, mapFile string, seed uint64) (*game, error) {
+	return l.add(gameID, mapFile, seed, "", nil)
+}
+
+// createFor creates a game a player asked for from the lobby, which counts
+// towards their limit.
+func (l *lobby) createFor(creator string, mapFile string, seed uint64) (*game, error) {
+	return l.add("", mapFile, seed, creator, nil)
+}
+
+// createMatch creates a game only the matched players may join.
+func (l *lobby) createMatch(players []string, mapFile string, seed uint64) (*game, error) {
+	return l.add("", mapFile, seed, "", players)
+}
+
+// add starts a new game. If creator is set, it counts towards their limit,
+// and if seats is, nobody else may join.
+func (l *lobby) add(gameID string, mapFile string, seed uint64, creator string, seats []string) (*game, error) {
+	if gameID == "" {
+		gameID = newGameID()
+	}
+	if strings.ContainsAny(gameID, ".*# \t") {
+		return nil, fmt.Errorf("game ID %q must be a single word without '.', '*' or '#'", gameID)
+	}
+	scenario, err := l.settings.scenario(mapFile)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.games[gameID]; ok {
+		return nil, fmt.Errorf("game %s already exists", gameID)
+	}
+	if creator != "" {
+		err := l.checkLimits(creator)
+		if err != nil {
+			return nil, err
+		}
+	}
+	g := newGame(l.conn, gameID, scenario, seed)
+	g.reserve(seats)
+	l.open(g, creator, true)
+	logger.Printf("Created game %s on %s\n", gameID, scenario.Name)
+	return g, nil
+}
+
+// load picks up a game username saved in their client as a new game, which
+// only they may play and which counts towards their limit. The save must hold
+// just their own state.
+func (l *lobby) load(username string, data []byte) (*game, error) {
+	sf, err := gamelogic.ParseSave(data)
+	if err != nil {
+		return nil, err
+	}
+	if sf.Scenario == nil {
+		return nil, errors.New("the save has no map")
+	}
+	if len(sf.Players) != 1 || sf.Players[0].Player.Username != username {
+		return nil, fmt.Errorf("the save does not belong to %s", username)
+	}
+	sf.GameID = newGameID()
+	sf.Seats = []string{username}
+	sf.Over = false
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err = l.checkLimits(username)
+	if err != nil {
+		return nil, err
+	}
+	g := restoreGame(l.conn, sf)
+	l.open(g, username, false)
+	logger.Printf("Loaded game %s on %s for %s\n", g.id, g.scenario.Name, username)
+	return g, nil
+}
+
+// open adds a new game to the lobby and starts its clocks, recording its
+// start unless it picks up from a save. If creator is set, the game counts
+// towards their limit and is closed if nobody joins it. The caller must hold
+// l.mu.
+func (l *lobby) open(g *game, creator string, start bool) {
+	g.events = l.events
+	g.stats = l.stats
+	g.savePath = l.savePath(g.id)
+	g.spectatorDelay = l.settings.spectatorDelay
+	if start {
+		g.start()
+	}
+	g.mu.Lock()
+	g.persist()
+	g.mu.Unlock()
+	l.games[g.id] = g
+	g.run(l.settings)
+	if creator != "" {
+		l.creators[g.id] = creator
+		time.AfterFunc(idleGameTimeout, func() { l.closeIfEmpty(g.id) })
+	}
+}
+
+// checkLimits returns an error if creator may not create another game. The
+// caller must hold l.mu.
+func (l *lobby) checkLimits(creator string) error {
+	if len(l.games) >= maxGames {
+		return errors.New("the server is running too many games, try again later")
+	}
+	created := 0
+	for _, username := range l.creators {
+		if username == creator {
+			created++
+		}
+	}
+	if created >= maxGamesPerPlayer {
+		return fmt.Errorf("%s already has %v games open", creator, created)
+	}
+	return nil
+}
+
+// closeIfEmpty closes a game nobody has joined.
+func (l *lobby) closeIfEmpty(gameID string) {
+	g, ok := l.game(gameID)
+	if !ok || g.summary().Players > 0 {
+		return
+	}
+	err := l.close(gameID)
+	if err != nil {
+		logger.Printf("Failed to close idle game %s: %v\n", gameID, err)
+		return
+	}
+	logger.Printf("Closed game %s as nobody joined it\n", gameID)
+}
+
+// restore picks up every game saved in the save directory and returns them.
+func (l *lobby) restore() ([]*game, error) {
+	if l.saveDir == "" {
+		return nil, nil
+	}
+	err := os.MkdirAll(l.saveDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(l.saveDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	restored := []*game{}
+	for _, path := range paths {
+		sf, err := gamelogic.ReadSave(path)
+		if err != nil {
+			return nil, err
+		}
+		g := restoreGame(l.conn, sf)
+		g.events = l.events
+		g.stats = l.stats
+		g.savePath = l.savePath(g.id)
+		g.spectatorDelay = l.settings.spectatorDelay
+		l.games[g.id] = g
+		if !g.over {
+			g.run(l.settings)
+		}
+		restored = append(restored, g)
+	}
+	return restored, nil
+}
+
+func (l *lobby) game(gameID string) (*game, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	g, ok := l.games[gameID]
+	return g, ok
+}
+
+// find returns the game with the given ID. An empty ID is fine while the
+// server is running only one game.
+func (l *lobby) find(gameID string) (*game, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if gameID == "" {
+		if len(l.games) != 1 {
+			return nil, errors.New("a game ID is required")
+		}
+		for _, g := range l.games {
+			return g, nil
+		}
+	}
+	g, ok := l.games[gameID]
+	if !ok {
+		return nil, fmt.Errorf("game %s does not exist", gameID)
+	}
+	return g, nil
+}
+
+// list describes every game, sorted by ID.
+func (l *lobby) list() []routing.GameSummary {
+	l.mu.Lock()
+	games := []*game{}
+	for _, g := range l.games {
+		games = append(games, g)
+	}
+	l.mu.Unlock()
+
+	summaries := []routing.GameSummary{}
+	for _, g := range games {
+		summaries = append(summaries, g.summary())
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].GameID < summaries[j].GameID
+	})
+	return summaries
+}
+
+// flush writes every game's unsaved changes to its save, e.g. before the
+// server exits.
+func (l *lobby) flush() {
+	l.mu.Lock()
+	games := []*game{}
+	for _, g := range l.games {
+		games = append(games, g)
+	}
+	l.mu.Unlock()
+
+	for _, g := range games {
+		g.flush()
+	}
+}
+
+// close ends a game, forgets it and deletes its save.
+func (l *lobby) close(gameID string) error {
+	l.mu.Lock()
+	g, ok := l.games[gameID]
+	delete(l.games, gameID)
+	delete(l.creators, gameID)
+	l.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("game %s does not exist", gameID)
+	}
+
+	g.close()
+	if path := l.savePath(gameID); path != "" {
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	logger.Printf("Closed game %s\n", gameID)
+	return nil
+}
+
+// handleIntent passes an intent on to the game it is for. Intents are only
+// accepted from the player and game named in their routing key,
+// intents.<game>.<user>, so a client can't give orders for anyone else.
+func (l *lobby) handleIntent(intent gamelogic.Intent, key string) pubsub.AckType {
+	if key != routing.GameKey(routing.IntentsPrefix, intent.GameID, intent.Username) {
+		logger.Printf("Discarding %s intent from %s for game %q sent as %s\n", intent.Kind, intent.Username, intent.GameID, key)
+		return pubsub.AckTypeNackDiscard
+	}
+	g, ok := l.game(intent.GameID)
+	if !ok {
+		logger.Printf("Discarding %s intent from %s for unknown game %q\n", intent.Kind, intent.Username, intent.GameID)
+		return pubsub.AckTypeNackDiscard
+	}
+	return g.handleIntent(intent)
+}
+
+// welcome greets a player joining one of the games.
+func (l *lobby) welcome(username string, gameID string) {
+	g, ok := l.game(gameID)
+	if !ok {
+		return
+	}
+	g.welcome(username)
+}
+
+// handleLobbyRequest lists the games, creates a new one on the default map,
+// loads one from a client's save or checks a spectator may watch one, for a
+// client picking a game. Requests are only answered for the player named in
+// their routing key, lobby.<user>, so nobody can create games, use up game
+// limits or watch games as someone else.
+func (l *lobby) handleLobbyRequest(req routing.LobbyRequest, key string) (routing.LobbyReply, error) {
+	reply := routing.LobbyReply{}
+	if key != fmt.Sprintf("%s.%s", routing.LobbyPrefix, req.Username) {
+		logger.Printf("Refusing %s lobby request from %s sent as %s\n", req.Kind, req.Username, key)
+		return reply, fmt.Errorf("lobby requests for %s must be sent as %s.%s", req.Username, routing.LobbyPrefix, req.Username)
+	}
+	switch req.Kind {
+	case routing.LobbyList:
+	case routing.LobbyCreate:
+		if req.Username == "" {
+			return reply, errors.New("a username is required to create a game")
+		}
+		g, err := l.createFor(req.Username, "", uint64(time.Now().UnixNano()))
+		if err != nil {
+			return reply, err
+		}
+		reply.Created = g.id
+		fmt.Println()
+		fmt.Printf("%s created game %s\n", req.Username, g.id)
+		fmt.Print("> ")
+	case routing.LobbyLoad:
+		if req.Username == "" {
+			return reply, errors.New("a username is required to load a game")
+		}
+		g, err := l.load(req.Username, req.Save)
+		if err != nil {
+			return reply, err
+		}
+		reply.Created = g.id
+		fmt.Println()
+		fmt.Printf("%s loaded a save as game %s\n", req.Username, g.id)
+		fmt.Print("> ")
+	case routing.LobbyWatch:
+		g, ok := l.game(req.GameID)
+		if !ok {
+			return reply, fmt.Errorf("game %s does not exist", req.GameID)
+		}
+		// Spectators see every move, so players could use a second client
+		// to see through the fog of war.
+		if g.hasPlayer(req.Username) {
+			return reply, fmt.Errorf("%s is playing in game %s and can't watch it", req.Username, req.GameID)
+		}
+	default:
+		return reply, fmt.Errorf("unknown lobby request %q", req.Kind)
+	}
+	reply.Games = l.list()
+	return reply, nil
+}
+
+func printGames(games []routing.GameSummary, current string) {
+	if len(games) == 0 {
+		fmt.Println("No games are running.")
+		return
+	}
+	fmt.Printf("%d game(s):\n", len(games))
+	for _, gs := range games {
+		marker := " "
+		if gs.GameID == current {
+			marker = "*"
+		}
+		status := ""
+		if gs.Over {
+			status = ", over"
+		}
+		fmt.Printf("%s %s: %s, %d player(s)%s\n", marker, gs.GameID, gs.Map, gs.Players, status)
+	}
+}
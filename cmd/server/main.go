@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
     "github.com/joho/godotenv"
 
-	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/unappendixed/bootdevpubsub/internal/gamelogic"
 	"github.com/unappendixed/bootdevpubsub/internal/pubsub"
 	"github.com/unappendixed/bootdevpubsub/internal/routing"
@@ -14,6 +17,25 @@ import (
 
 const logfilepath string = "server.log"
 
+// How often travelling units advance if the scenario allows travel but
+// TRAVEL_INTERVAL isn't set.
+const defaultTravelInterval = 10 * time.Second
+
+// How often territories pay income in real time if INCOME_INTERVAL isn't set.
+const defaultIncomeInterval = 30 * time.Second
+
+// How far behind the game spectators see moves if SPECTATOR_DELAY isn't set.
+const defaultSpectatorDelay = time.Minute
+
+// Where game events are recorded if EVENT_LOG isn't set.
+const defaultEventLogPath = "events.jsonl"
+
+// Where player statistics are kept if STATS_FILE isn't set.
+const defaultStatsPath = "stats.json"
+
+// How often the server writes changed statistics to STATS_FILE.
+const statsSaveInterval = time.Second
+
 var logger log.Logger
 
 func main() {
@@ -30,22 +52,16 @@ func main() {
 		panic(fmt.Errorf("Failed to open logfile %q: %w", logfilepath, err))
 	}
 	logger = *log.New(logfile, "", log.Ldate|log.Ltime)
-	conn, err := amqp.Dial(connstr)
+	conn, err := pubsub.Dial(connstr)
 	if err != nil {
 		panic(err)
 	}
 	defer conn.Close()
 
-	ch, err := conn.Channel()
-	if err != nil {
-		panic(err)
-	}
-    defer ch.Close()
-
-    err = pubsub.SubscribeGob[routing.GameLog](
+    err = pubsub.SubscribeBrokerGob[routing.GameLog](
         conn,
         routing.ExchangePerilTopic,
-        fmt.Sprintf("%s.*", routing.GameLogSlug),
+        routing.GameKey(routing.GameLogSlug, "*", "*"),
         "game_logs",
         pubsub.QueueTypeDurable,
         func(gl routing.GameLog) pubsub.AckType {
@@ -66,7 +82,200 @@ func main() {
         logger.Println("Failed to bind to game logs exchange")
     }
 
+    settings := gameSettings{
+        mapFile:        os.Getenv("MAP_FILE"),
+        combat:         os.Getenv("COMBAT_MODEL"),
+        travelInterval: defaultTravelInterval,
+        incomeInterval: defaultIncomeInterval,
+        spectatorDelay: defaultSpectatorDelay,
+    }
+
+    if interval, found := os.LookupEnv("TRAVEL_INTERVAL"); found {
+        settings.travelInterval, err = time.ParseDuration(interval)
+        if err != nil || settings.travelInterval <= 0 {
+            panic(fmt.Errorf("Invalid TRAVEL_INTERVAL %q", interval))
+        }
+        settings.travel = true
+    }
+
+    if value, found := os.LookupEnv("TIME_LIMIT"); found {
+        settings.timeLimit, err = time.ParseDuration(value)
+        if err != nil || settings.timeLimit <= 0 {
+            panic(fmt.Errorf("Invalid TIME_LIMIT %q", value))
+        }
+    }
+
+    if interval, found := os.LookupEnv("TURN_INTERVAL"); found {
+        settings.turnInterval, err = time.ParseDuration(interval)
+        if err != nil || settings.turnInterval <= 0 {
+            panic(fmt.Errorf("Invalid TURN_INTERVAL %q", interval))
+        }
+    }
+
+    if interval, found := os.LookupEnv("INCOME_INTERVAL"); found {
+        settings.incomeInterval, err = time.ParseDuration(interval)
+        if err != nil || settings.incomeInterval <= 0 {
+            panic(fmt.Errorf("Invalid INCOME_INTERVAL %q", interval))
+        }
+    }
+
+    if value, found := os.LookupEnv("SPECTATOR_DELAY"); found {
+        settings.spectatorDelay, err = time.ParseDuration(value)
+        if err != nil || settings.spectatorDelay < 0 {
+            panic(fmt.Errorf("Invalid SPECTATOR_DELAY %q", value))
+        }
+    }
+
+    // Catch a bad map or combat model before anyone tries to play on it.
+    _, err = settings.scenario("")
+    if err != nil {
+        panic(err)
+    }
+
+    seed := uint64(time.Now().UnixNano())
+    if value, found := os.LookupEnv("GAME_SEED"); found {
+        seed, err = strconv.ParseUint(value, 10, 64)
+        if err != nil {
+            panic(fmt.Errorf("Invalid GAME_SEED %q", value))
+        }
+    }
+
+    var events *gamelogic.EventLog
+    eventLogPath := defaultEventLogPath
+    if path, found := os.LookupEnv("EVENT_LOG"); found {
+        eventLogPath = path
+    }
+    if eventLogPath != "" {
+        events, err = gamelogic.OpenEventLog(eventLogPath)
+        if err != nil {
+            panic(err)
+        }
+        defer events.Close()
+    }
+
+    statsPath := defaultStatsPath
+    if path, found := os.LookupEnv("STATS_FILE"); found {
+        statsPath = path
+    }
+    stats, err := gamelogic.LoadStats(statsPath)
+    if err != nil {
+        panic(err)
+    }
+    go every(statsSaveInterval, nil, func() {
+        err := stats.Flush()
+        if err != nil {
+            logger.Printf("Failed to save stats: %v\n", err)
+        }
+    })
+
+    lob := newLobby(conn, settings, os.Getenv("SAVE_DIR"), events, stats)
+
+    mapsDir := defaultMapsDir
+    if dir, found := os.LookupEnv("MAPS_DIR"); found {
+        mapsDir = dir
+    }
+    matches := newMatchmaker(conn, lob, mapsDir)
+
+    err = pubsub.SubscribeBrokerJSONWithKey[gamelogic.Intent](
+        conn,
+        routing.ExchangePerilTopic,
+        fmt.Sprintf("%s.*.*", routing.IntentsPrefix),
+        routing.IntentsPrefix,
+        pubsub.QueueTypeTransient,
+        lob.handleIntent,
+    )
+    ownsGames := err == nil
+    if !ownsGames {
+        // The intents queue is exclusive, so only one server can run the
+        // games. Any others still help drain the game logs.
+        fmt.Println("Another server is already running the games; only processing game logs.")
+        logger.Printf("Failed to subscribe to intents: %v\n", err)
+    }
+
+    current := ""
+    if ownsGames {
+        restored, err := lob.restore()
+        if err != nil {
+            panic(err)
+        }
+        for _, g := range restored {
+            fmt.Printf("Resumed game %s from %s\n", g.id, g.savePath)
+            current = g.id
+        }
+        if len(restored) == 0 {
+            g, err := lob.create(os.Getenv("GAME_ID"), "", seed)
+            if err != nil {
+                panic(err)
+            }
+            current = g.id
+        }
+
+        err = pubsub.ServeWithKey(
+            conn,
+            routing.ExchangePerilTopic,
+            fmt.Sprintf("%s.*", routing.LobbyPrefix),
+            routing.LobbyPrefix,
+            pubsub.QueueTypeTransient,
+            lob.handleLobbyRequest,
+        )
+        if err != nil {
+            panic(fmt.Errorf("Failed to subscribe to the lobby: %w", err))
+        }
+
+        err = pubsub.SubscribeBrokerJSONWithKey[routing.MatchRequest](
+            conn,
+            routing.ExchangePerilTopic,
+            fmt.Sprintf("%s.*", routing.MatchmakingPrefix),
+            routing.MatchmakingPrefix,
+            pubsub.QueueTypeTransient,
+            matches.handleRequest,
+        )
+        if err != nil {
+            panic(fmt.Errorf("Failed to subscribe to matchmaking: %w", err))
+        }
+    }
+
+    players := newPlayerRegistry()
+    err = pubsub.SubscribeBrokerJSONWithKey[routing.PlayerPresence](
+        conn,
+        routing.ExchangePerilTopic,
+        fmt.Sprintf("%s.*", routing.PresencePrefix),
+        "",
+        pubsub.QueueTypeTransient,
+        handlerPresence(players, lob.welcome),
+    )
+    if err != nil {
+        panic(fmt.Errorf("Failed to subscribe to player presence: %w", err))
+    }
+    go players.watchExpiry(routing.PresenceHeartbeatInterval, routing.PresenceTimeout)
+
+    srv := &server{
+        conn:    conn,
+        players: players,
+        lobby:   lob,
+        matches: matches,
+    }
+
+    if addr, found := os.LookupEnv("ADMIN_HTTP_ADDR"); found {
+        srv.apiToken = os.Getenv("ADMIN_API_TOKEN")
+        if srv.apiToken == "" {
+            panic("ADMIN_API_TOKEN must be set to enable the admin API")
+        }
+        go func() {
+            err := srv.serveAPI(addr)
+            if err != nil {
+                fmt.Printf("Admin API stopped: %v\n", err)
+                logger.Printf("Admin API stopped: %v\n", err)
+            }
+        }()
+        fmt.Printf("Admin API listening on %s\n", addr)
+    }
+
 	fmt.Printf("Connected to %s\n", connstr)
+	if settings.turnInterval > 0 {
+		fmt.Printf("Turns last %v\n", settings.turnInterval)
+	}
+	printGames(lob.list(), current)
 	gamelogic.PrintServerHelp()
 
     outer: for {
@@ -77,27 +286,166 @@ func main() {
 
         switch input[0] {
 
-        case "pause":
-			fmt.Println("Sending pause message...")
-			pubsub.PublishJSON(
-                ch,
-				routing.ExchangePerilDirect,
-				routing.PauseKey,
-				routing.PlayingState{IsPaused: true},
-            )
-        case "resume":
-            fmt.Println("Sending resume message...")
-            pubsub.PublishJSON(
-                ch,
-                routing.ExchangePerilDirect,
-                routing.PauseKey,
-                routing.PlayingState{IsPaused: false},
-            )
+        case "pause", "resume":
+            paused := input[0] == "pause"
+            username := ""
+            if len(input) > 1 {
+                username = input[1]
+            }
+            if username == "" {
+                fmt.Printf("Sending %s message...\n", input[0])
+            } else {
+                fmt.Printf("Sending %s message to %s...\n", input[0], username)
+            }
+            err := srv.setPaused(current, username, paused)
+            if err != nil {
+                fmt.Println(err)
+            }
+        case "announce", "broadcast":
+            if len(input) < 2 {
+                fmt.Printf("Usage: %s <message>\n", input[0])
+                continue
+            }
+            err := srv.announce(strings.Join(input[1:], " "))
+            if err != nil {
+                fmt.Println(err)
+                continue
+            }
+            fmt.Println("Announcement sent.")
+        case "kick":
+            if len(input) < 2 {
+                fmt.Println("Usage: kick <username> [reason]")
+                continue
+            }
+            reason := "Kicked by the server."
+            if len(input) > 2 {
+                reason = strings.Join(input[2:], " ")
+            }
+            err := srv.kick(input[1], reason)
+            if err != nil {
+                fmt.Println(err)
+                continue
+            }
+            fmt.Printf("Kicked %s.\n", input[1])
+        case "players":
+            printPlayers(players)
+        case "games":
+            printGames(lob.list(), current)
+        case "matchmaking":
+            printMatchmaking(matches)
+        case "create":
+            mapFile := ""
+            if len(input) > 1 {
+                mapFile = input[1]
+            }
+            g, err := lob.create("", mapFile, uint64(time.Now().UnixNano()))
+            if err != nil {
+                fmt.Println(err)
+                continue
+            }
+            fmt.Printf("Created game %s on %s\n", g.id, g.scenario.Name)
+        case "use":
+            if len(input) < 2 {
+                fmt.Println("Usage: use <game>")
+                continue
+            }
+            if _, ok := lob.game(input[1]); !ok {
+                fmt.Printf("Game %s does not exist\n", input[1])
+                continue
+            }
+            current = input[1]
+            fmt.Printf("Using game %s\n", current)
+        case "close":
+            if len(input) < 2 {
+                fmt.Println("Usage: close <game>")
+                continue
+            }
+            err := lob.close(input[1])
+            if err != nil {
+                fmt.Println(err)
+                continue
+            }
+            if current == input[1] {
+                current = ""
+            }
+            fmt.Printf("Closed game %s\n", input[1])
+        case "standings":
+            g, err := lob.find(current)
+            if err != nil {
+                fmt.Println(err)
+                continue
+            }
+            gamelogic.PrintStandings(g.standings())
+        case "save":
+            g, err := lob.find(current)
+            if err != nil {
+                fmt.Println(err)
+                continue
+            }
+            path := g.savePath
+            if len(input) > 1 {
+                path = input[1]
+            }
+            if path == "" {
+                fmt.Println("Usage: save <file>")
+                continue
+            }
+            err = g.saveTo(path)
+            if err != nil {
+                fmt.Println(err)
+                continue
+            }
+            fmt.Printf("Saved game %s to %s\n", g.id, path)
+        case "logs":
+            n := 10
+            if len(input) > 1 {
+                parsed, err := strconv.Atoi(input[1])
+                if err != nil || parsed <= 0 {
+                    fmt.Println("Argument must be a positive integer")
+                    continue
+                }
+                n = parsed
+            }
+            printRecentLogs(srv, n)
+        case "queues":
+            printQueueDepths(srv)
+        case "leaderboard":
+            board := stats.Leaderboard()
+            if len(input) > 1 {
+                n, err := strconv.Atoi(input[1])
+                if err != nil || n <= 0 {
+                    fmt.Println("Argument must be a positive integer")
+                    continue
+                }
+                board = board[:min(n, len(board))]
+            }
+            gamelogic.PrintLeaderboard(board)
+        case "help":
+            gamelogic.PrintServerHelp()
         case "quit":
             fmt.Println("Exiting...")
+            lob.flush()
+            err := stats.Flush()
+            if err != nil {
+                fmt.Println(err)
+            }
             break outer
         default:
             fmt.Printf("Unknown command: %q\n", input[0])
 		}
     }
 }
+
+// every calls f once per interval until done is closed.
+func every(interval time.Duration, done <-chan struct{}, f func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			f()
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unappendixed/bootdevpubsub/internal/pubsub"
+	"github.com/unappendixed/bootdevpubsub/internal/routing"
+)
+
+// Where matchmaking and the admin API look up maps by name if MAPS_DIR isn't
+// set.
+const defaultMapsDir = "maps"
+
+const (
+	defaultMatchPlayers = 2
+	maxMatchPlayers     = 8
+)
+
+// Players waiting longer than this are dropped from the queue. Clients give
+// up waiting at the same point.
+const matchTimeout = 5 * time.Minute
+
+// matchPool is everyone waiting for a game with the same player count and
+// map.
+type matchPool struct {
+	players int
+	mapName string
+}
+
+type ticket struct {
+	username    string
+	requestedAt time.Time
+}
+
+// matchmaker groups players asking for the same kind of game and starts a new
+// game for each group as soon as it is full.
+type matchmaker struct {
+	lobby   *lobby
+	mapsDir string
+	waiting map[matchPool][]ticket
+	conn    pubsub.Broker
+	mu      *sync.Mutex
+}
+
+func newMatchmaker(conn pubsub.Broker, lob *lobby, mapsDir string) *matchmaker {
+	return &matchmaker{
+		lobby:   lob,
+		mapsDir: mapsDir,
+		waiting: map[matchPool][]ticket{},
+		conn:    conn,
+		mu:      &sync.Mutex{},
+	}
+}
+
+// mapFile returns the file for a map name, or an empty path for the
+// server's default map.
+func (mm *matchmaker) mapFile(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	if strings.ContainsAny(name, `/\.`) {
+		return "", fmt.Errorf("invalid map name %q", name)
+	}
+	path := filepath.Join(mm.mapsDir, name+".json")
+	_, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("there is no map called %s", name)
+	}
+	return path, nil
+}
+
+// remove takes username out of every pool. The caller must hold mm.mu.
+func (mm *matchmaker) remove(username string) {
+	for pool, tickets := range mm.waiting {
+		mm.waiting[pool] = slices.DeleteFunc(tickets, func(t ticket) bool {
+			return t.username == username
+		})
+	}
+}
+
+// expire drops every ticket older than matchTimeout. The caller must hold
+// mm.mu.
+func (mm *matchmaker) expire(now time.Time) {
+	for pool, tickets := range mm.waiting {
+		mm.waiting[pool] = slices.DeleteFunc(tickets, func(t ticket) bool {
+			return now.Sub(t.requestedAt) > matchTimeout
+		})
+	}
+}
+
+// handleRequest queues a player for a match. Requests are only accepted from
+// the player named in their routing key, matchmaking.<user>, so nobody can
+// queue or cancel for someone else.
+func (mm *matchmaker) handleRequest(req routing.MatchRequest, key string) pubsub.AckType {
+	if req.Username == "" {
+		return pubsub.AckTypeNackDiscard
+	}
+	if key != fmt.Sprintf("%s.%s", routing.MatchmakingPrefix, req.Username) {
+		logger.Printf("Discarding match request from %s sent as %s\n", req.Username, key)
+		return pubsub.AckTypeNackDiscard
+	}
+
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	// A new request replaces any earlier one.
+	mm.remove(req.Username)
+	if req.Cancel {
+		return pubsub.AckTypeAck
+	}
+
+	players := req.Players
+	if players == 0 {
+		players = defaultMatchPlayers
+	}
+	if players < 2 || players > maxMatchPlayers {
+		mm.reply(req.Username, routing.Match{
+			Error: fmt.Sprintf("games must have between 2 and %v players", maxMatchPlayers),
+		})
+		return pubsub.AckTypeAck
+	}
+	mapFile, err := mm.mapFile(req.Map)
+	if err != nil {
+		mm.reply(req.Username, routing.Match{Error: err.Error()})
+		return pubsub.AckTypeAck
+	}
+
+	now := time.Now()
+	mm.expire(now)
+	pool := matchPool{players: players, mapName: req.Map}
+	mm.waiting[pool] = append(mm.waiting[pool], ticket{username: req.Username, requestedAt: now})
+	if len(mm.waiting[pool]) < players {
+		return pubsub.AckTypeAck
+	}
+
+	matched := []string{}
+	for _, t := range mm.waiting[pool] {
+		matched = append(matched, t.username)
+	}
+	delete(mm.waiting, pool)
+
+	g, err := mm.lobby.createMatch(matched, mapFile, uint64(now.UnixNano()))
+	if err != nil {
+		logger.Printf("Failed to create a game for %v: %v\n", matched, err)
+		for _, username := range matched {
+			mm.reply(username, routing.Match{Error: "the server could not create a game"})
+		}
+		return pubsub.AckTypeAck
+	}
+	fmt.Println()
+	fmt.Printf("Matched %s into game %s\n", strings.Join(matched, ", "), g.id)
+	fmt.Print("> ")
+	for _, username := range matched {
+		mm.reply(username, routing.Match{
+			GameID:  g.id,
+			Map:     g.scenario.Name,
+			Players: matched,
+		})
+	}
+	return pubsub.AckTypeAck
+}
+
+// reply sends a match straight to a single player.
+func (mm *matchmaker) reply(username string, match routing.Match) {
+	err := pubsub.PublishBrokerJSON(
+		mm.conn,
+		routing.ExchangePerilDirect,
+		fmt.Sprintf("%s.%s", routing.MatchesPrefix, username),
+		match,
+	)
+	if err != nil {
+		logger.Printf("Failed to send match to %s: %v\n", username, err)
+	}
+}
+
+// queued returns how many players are waiting in each pool.
+func (mm *matchmaker) queued() map[matchPool]int {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	mm.expire(time.Now())
+	queued := map[matchPool]int{}
+	for pool, tickets := range mm.waiting {
+		if len(tickets) > 0 {
+			queued[pool] = len(tickets)
+		}
+	}
+	return queued
+}
+
+func printMatchmaking(mm *matchmaker) {
+	queued := mm.queued()
+	if len(queued) == 0 {
+		fmt.Println("Nobody is waiting for a match.")
+		return
+	}
+	for pool, n := range queued {
+		mapName := pool.mapName
+		if mapName == "" {
+			mapName = "the default map"
+		}
+		fmt.Printf("* %d of %d player(s) waiting for %s\n", n, pool.players, mapName)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/unappendixed/bootdevpubsub/internal/pubsub"
+	"github.com/unappendixed/bootdevpubsub/internal/routing"
+)
+
+type onlinePlayer struct {
+	Username string
+	GameID   string
+	// Spectator is set if they are only watching the game.
+	Spectator bool
+	JoinedAt  time.Time
+	LastSeen  time.Time
+}
+
+type playerRegistry struct {
+	players map[string]onlinePlayer
+	mu      *sync.RWMutex
+}
+
+func newPlayerRegistry() *playerRegistry {
+	return &playerRegistry{
+		players: map[string]onlinePlayer{},
+		mu:      &sync.RWMutex{},
+	}
+}
+
+func (pr *playerRegistry) seen(pp routing.PlayerPresence, at time.Time) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	p, ok := pr.players[pp.Username]
+	if !ok {
+		p = onlinePlayer{Username: pp.Username, JoinedAt: at}
+	}
+	p.GameID = pp.GameID
+	p.Spectator = pp.Spectator
+	p.LastSeen = at
+	pr.players[pp.Username] = p
+}
+
+func (pr *playerRegistry) remove(username string) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	delete(pr.players, username)
+}
+
+// expire drops every player whose last heartbeat is older than timeout and
+// returns their usernames.
+func (pr *playerRegistry) expire(now time.Time, timeout time.Duration) []string {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	expired := []string{}
+	for username, p := range pr.players {
+		if now.Sub(p.LastSeen) > timeout {
+			delete(pr.players, username)
+			expired = append(expired, username)
+		}
+	}
+	return expired
+}
+
+func (pr *playerRegistry) snapshot() []onlinePlayer {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	players := []onlinePlayer{}
+	for _, p := range pr.players {
+		players = append(players, p)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].Username < players[j].Username
+	})
+	return players
+}
+
+func (pr *playerRegistry) watchExpiry(interval time.Duration, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, username := range pr.expire(now, timeout) {
+			logger.Printf("%s timed out\n", username)
+		}
+	}
+}
+
+// handlerPresence keeps the registry up to date, calling onJoin whenever a
+// player joins a game. Events are only accepted from the player named in
+// their routing key, presence.<user>, so nobody can join or leave for someone
+// else.
+func handlerPresence(pr *playerRegistry, onJoin func(username string, gameID string)) func(routing.PlayerPresence, string) pubsub.AckType {
+	return func(pp routing.PlayerPresence, key string) pubsub.AckType {
+		if pp.Username == "" {
+			return pubsub.AckTypeNackDiscard
+		}
+		if key != fmt.Sprintf("%s.%s", routing.PresencePrefix, pp.Username) {
+			logger.Printf("Discarding %s presence from %s sent as %s\n", pp.Kind, pp.Username, key)
+			return pubsub.AckTypeNackDiscard
+		}
+
+		switch pp.Kind {
+		case routing.PresenceJoin:
+			pr.seen(pp, time.Now())
+			logger.Printf("%s joined game %s\n", pp.Username, pp.GameID)
+			onJoin(pp.Username, pp.GameID)
+		case routing.PresenceHeartbeat:
+			pr.seen(pp, time.Now())
+		case routing.PresenceLeave:
+			pr.remove(pp.Username)
+			logger.Printf("%s left\n", pp.Username)
+		default:
+			return pubsub.AckTypeNackDiscard
+		}
+		return pubsub.AckTypeAck
+	}
+}
+
+func printPlayers(pr *playerRegistry) {
+	players := pr.snapshot()
+	if len(players) == 0 {
+		fmt.Println("No players are online.")
+		return
+	}
+	fmt.Printf("%d player(s) online:\n", len(players))
+	now := time.Now()
+	for _, p := range players {
+		role := "playing"
+		if p.Spectator {
+			role = "watching"
+		}
+		fmt.Printf(
+			"* %s %s game %s (joined %s, last seen %s ago)\n",
+			p.Username,
+			role,
+			p.GameID,
+			p.JoinedAt.Format(time.Kitchen),
+			now.Sub(p.LastSeen).Round(time.Second),
+		)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"time"
+
+	"github.com/unappendixed/bootdevpubsub/internal/gamelogic"
+	"github.com/unappendixed/bootdevpubsub/internal/pubsub"
+)
+
+// How long the server waits after a change before saving a game.
+const saveDelay = time.Second
+
+// newGameID returns a short random ID for a new game.
+func newGameID() string {
+	b := make([]byte, 4)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// restoreGame picks a saved game back up where it left off.
+func restoreGame(conn pubsub.Broker, sf gamelogic.SaveFile) *game {
+	g := newGame(conn, sf.GameID, sf.Scenario, sf.Seed)
+	g.paused = sf.Paused
+	g.over = sf.Over
+	g.turn.Number = sf.Turn
+	g.orders = sf.Orders
+	g.timeUsed = sf.TimeUsed
+	g.reserve(sf.Seats)
+	for _, s := range sf.Players {
+		gs := gamelogic.NewGameState(s.Player.Username)
+		gs.SetGameID(g.id)
+		gs.SetScenario(g.scenario)
+		gs.SetSeed(g.seed)
+		gs.Restore(s)
+		g.states[s.Player.Username] = gs
+	}
+	return g
+}
+
+// snapshot captures the whole game for saving. The caller must hold g.mu.
+func (g *game) snapshot() gamelogic.SaveFile {
+	sf := gamelogic.SaveFile{
+		GameID:   g.id,
+		Scenario: g.scenario,
+		Seed:     g.seed,
+		Paused:   g.paused,
+		Orders:   slices.Clone(g.orders),
+		Over:     g.over,
+	}
+	if g.turnInterval > 0 {
+		sf.Turn = g.turn.Number
+	}
+	if !g.deadline.IsZero() {
+		limit := g.scenario.TimeLimit()
+		sf.TimeUsed = min(limit-time.Until(g.deadline), limit)
+	}
+	for username := range g.seats {
+		sf.Seats = append(sf.Seats, username)
+	}
+	slices.Sort(sf.Seats)
+	for _, username := range g.usernames() {
+		sf.Players = append(sf.Players, g.states[username].Snapshot())
+	}
+	return sf
+}
+
+// persist saves the game soon if it has a save file. Changes made within
+// saveDelay of each other are saved together, outside g.mu, so a burst of
+// intents doesn't rewrite the file for each one. The caller must hold g.mu.
+func (g *game) persist() {
+	if g.savePath == "" || g.savePending {
+		return
+	}
+	g.savePending = true
+	time.AfterFunc(saveDelay, g.flush)
+}
+
+// flush saves the game now if it has changes waiting to be saved, logging any
+// failure.
+func (g *game) flush() {
+	g.saveMu.Lock()
+	defer g.saveMu.Unlock()
+	g.mu.Lock()
+	if !g.savePending || g.savePath == "" {
+		g.mu.Unlock()
+		return
+	}
+	g.savePending = false
+	path := g.savePath
+	sf := g.snapshot()
+	g.mu.Unlock()
+
+	err := gamelogic.WriteSave(path, sf)
+	if err != nil {
+		logger.Printf("Failed to save game %s: %v\n", g.id, err)
+	}
+}
+
+// saveTo writes the game to path.
+func (g *game) saveTo(path string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return gamelogic.WriteSave(path, g.snapshot())
+}
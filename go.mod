@@ -3,6 +3,8 @@ module github.com/unappendixed/bootdevpubsub
 go 1.22.1
 
 require (
+	github.com/go-stomp/stomp/v3 v3.1.3
+	github.com/gorilla/websocket v1.5.3
 	github.com/joho/godotenv v1.5.1
 	github.com/rabbitmq/amqp091-go v1.10.0
 )
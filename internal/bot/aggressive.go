@@ -0,0 +1,58 @@
+package bot
+
+import (
+	"slices"
+
+	"github.com/unappendixed/bootdevpubsub/internal/gamelogic"
+)
+
+// aggressiveStrategy buys the strongest unit it can, as close to the enemy as
+// it can, and sends every army after the nearest enemy it knows of. Until it
+// has seen one, it spreads out to claim new ground instead.
+type aggressiveStrategy struct{}
+
+func (aggressiveStrategy) Name() string {
+	return "aggressive"
+}
+
+func (aggressiveStrategy) Plan(v View) [][]string {
+	commands := [][]string{}
+
+	targets := v.enemyLocations()
+	if len(targets) == 0 {
+		held := v.held()
+		for _, info := range v.Scenario.Locations {
+			if !slices.Contains(held, info.Name) {
+				targets = append(targets, info.Name)
+			}
+		}
+	}
+
+	if affordable := v.affordable(); len(affordable) > 0 {
+		best := gamelogic.Location("")
+		bestDistance := -1
+		for _, loc := range v.spawnable() {
+			target, ok := v.nearest(loc, targets)
+			if !ok {
+				continue
+			}
+			d := v.distance(loc, target)
+			if bestDistance < 0 || d < bestDistance {
+				best, bestDistance = loc, d
+			}
+		}
+		if best != "" {
+			commands = append(commands, spawnCommand(best, affordable[0].Rank))
+		}
+	}
+
+	groups := v.unitsByLocation()
+	for _, from := range sortedLocations(groups) {
+		target, ok := v.nearest(from, targets)
+		if !ok || target == from {
+			continue
+		}
+		commands = append(commands, moveCommand(v.toward(from, target), groups[from]))
+	}
+	return commands
+}
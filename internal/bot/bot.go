@@ -0,0 +1,370 @@
+package bot
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unappendixed/bootdevpubsub/internal/gamelogic"
+	"github.com/unappendixed/bootdevpubsub/internal/pubsub"
+	"github.com/unappendixed/bootdevpubsub/internal/routing"
+)
+
+// Bot plays a game the way a player using the client would: it gets the same
+// messages, checks its orders against the same GameState and sends the same
+// intents. Its Strategy decides what those orders are.
+type Bot struct {
+	Username string
+	GameID   string
+	strategy Strategy
+	state    *gamelogic.GameState
+	// ready is set once the game info has arrived. Until then the bot doesn't
+	// know the map.
+	ready bool
+	// lastTurn is the last turn orders were given in, in games played in
+	// turns.
+	lastTurn int
+	// enemies holds every enemy unit seen so far, keyed by player and unit ID.
+	enemies map[string]map[int]gamelogic.Unit
+	rand    *rand.Rand
+	mu      *sync.Mutex
+}
+
+// New creates a bot that plays gameID as username. Bots given the same seed
+// and username make the same choices when shown the same game.
+func New(username string, gameID string, strategy Strategy, seed uint64) *Bot {
+	h := fnv.New64a()
+	h.Write([]byte(username))
+	state := gamelogic.NewGameState(username)
+	state.SetGameID(gameID)
+	return &Bot{
+		Username: username,
+		GameID:   gameID,
+		strategy: strategy,
+		state:    state,
+		enemies:  map[string]map[int]gamelogic.Unit{},
+		rand:     rand.New(rand.NewPCG(seed, h.Sum64())),
+		mu:       &sync.Mutex{},
+	}
+}
+
+// Run joins the game and plays until it is over, the bot is kicked or done is
+// closed. Orders are given every interval, or once a turn in games played in
+// turns.
+func (b *Bot) Run(conn pubsub.Broker, interval time.Duration, done <-chan struct{}) error {
+	stop := make(chan struct{}, 1)
+	err := b.subscribe(conn, stop)
+	if err != nil {
+		return err
+	}
+
+	presence := routing.PlayerPresence{Username: b.Username, GameID: b.GameID}
+	err = publishPresence(conn, presence, routing.PresenceJoin)
+	if err != nil {
+		return fmt.Errorf("Failed to announce join: %w", err)
+	}
+	defer publishPresence(conn, presence, routing.PresenceLeave)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	heartbeat := time.NewTicker(routing.PresenceHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-stop:
+			return nil
+		case <-heartbeat.C:
+			err := publishPresence(conn, presence, routing.PresenceHeartbeat)
+			if err != nil {
+				b.logf("failed to publish heartbeat: %v", err)
+			}
+		case <-ticker.C:
+			err := b.act(conn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// act asks the strategy for orders and sends whichever of them are valid.
+func (b *Bot) act(conn pubsub.Broker) error {
+	view, ok := b.view()
+	if !ok {
+		return nil
+	}
+	key := routing.GameKey(routing.IntentsPrefix, b.GameID, b.Username)
+	for _, words := range b.strategy.Plan(view) {
+		var intent gamelogic.Intent
+		var err error
+		switch words[0] {
+		case "spawn":
+			intent, err = b.state.CommandSpawn(words)
+		case "move":
+			intent, err = b.state.CommandMove(words)
+		default:
+			err = fmt.Errorf("unknown command %q", words[0])
+		}
+		if err != nil {
+			continue
+		}
+		err = pubsub.PublishBrokerJSON(conn, routing.ExchangePerilTopic, key, intent)
+		if err != nil {
+			return fmt.Errorf("Failed to send %s: %w", words[0], err)
+		}
+		b.logf("%s", strings.Join(words, " "))
+	}
+	return nil
+}
+
+// view returns what the strategy gets to see, or false if it isn't time to
+// give orders.
+func (b *Bot) view() (View, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.ready || b.state.IsPaused() {
+		return View{}, false
+	}
+	if turn, ok := b.state.GetTurn(); ok {
+		if turn.Number == b.lastTurn {
+			return View{}, false
+		}
+		b.lastTurn = turn.Number
+	}
+
+	enemies := map[string][]gamelogic.Unit{}
+	for username, units := range b.enemies {
+		if b.state.IsAlly(username) {
+			continue
+		}
+		for _, unit := range units {
+			enemies[username] = append(enemies[username], unit)
+		}
+	}
+	return View{
+		Player:   b.state.GetPlayerSnap(),
+		Scenario: b.state.GetScenario(),
+		Enemies:  enemies,
+		Rand:     b.rand,
+	}, true
+}
+
+// spot records where another player's units were seen.
+func (b *Bot) spot(p gamelogic.Player) {
+	if p.Username == b.Username {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	units, ok := b.enemies[p.Username]
+	if !ok {
+		units = map[int]gamelogic.Unit{}
+		b.enemies[p.Username] = units
+	}
+	for id, unit := range p.Units {
+		units[id] = unit
+	}
+}
+
+func (b *Bot) forget(username string, casualties []int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, id := range casualties {
+		delete(b.enemies[username], id)
+	}
+}
+
+func (b *Bot) logf(format string, args ...any) {
+	fmt.Printf("[%s] %s: %s\n", time.Now().Format(time.TimeOnly), b.Username, fmt.Sprintf(format, args...))
+}
+
+// subscribe subscribes to everything the client would. stop is signalled
+// when the game ends or the bot is kicked.
+func (b *Bot) subscribe(conn pubsub.Broker, stop chan<- struct{}) error {
+	signal := func() {
+		select {
+		case stop <- struct{}{}:
+		default:
+		}
+	}
+
+	err := pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.GameInfoPrefix, b.GameID, b.Username),
+		"",
+		pubsub.QueueTypeTransient,
+		func(gi gamelogic.GameInfo) pubsub.AckType {
+			err := b.state.ApplyGameInfo(gi)
+			if err != nil {
+				b.logf("%v", err)
+				return pubsub.AckTypeNackDiscard
+			}
+			b.mu.Lock()
+			b.ready = true
+			b.mu.Unlock()
+			b.logf("playing %s on %s with the %s strategy", gi.GameID, gi.Scenario.Name, b.strategy.Name())
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to game info: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.StatePrefix, b.GameID, b.Username),
+		"",
+		pubsub.QueueTypeTransient,
+		func(su gamelogic.StateUpdate) pubsub.AckType {
+			if su.Player.Username != b.Username {
+				return pubsub.AckTypeAck
+			}
+			if su.Error != "" {
+				b.logf("rejected: %s", su.Error)
+			}
+			b.state.SetPlayer(su.Player)
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to state updates: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.TurnKey, b.GameID),
+		"",
+		pubsub.QueueTypeTransient,
+		func(turn gamelogic.Turn) pubsub.AckType {
+			b.state.SetTurn(&turn)
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to turns: %w", err)
+	}
+
+	// Incoming moves, filtered by the server to what the bot can see
+	err = pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.ArmyMovesPrefix, b.GameID, b.Username),
+		"",
+		pubsub.QueueTypeTransient,
+		func(move gamelogic.ArmyMove) pubsub.AckType {
+			b.spot(move.Player)
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to army moves: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.WarResultsPrefix, b.GameID, "*"),
+		"",
+		pubsub.QueueTypeTransient,
+		func(wr gamelogic.WarResult) pubsub.AckType {
+			b.forget(wr.Attacker, wr.AttackerCasualties)
+			b.forget(wr.Defender, wr.DefenderCasualties)
+			for ally, casualties := range wr.AllyCasualties {
+				b.forget(ally, casualties)
+			}
+			if !wr.Involves(b.Username) {
+				return pubsub.AckTypeAck
+			}
+			switch {
+			case wr.Draw:
+				b.logf("drew in %s", wr.Location)
+			case wr.Winner == b.Username:
+				b.logf("won in %s against %s", wr.Location, wr.Loser)
+			default:
+				b.logf("lost in %s to %s", wr.Location, wr.Winner)
+			}
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to war results: %w", err)
+	}
+
+	// Pauses for the whole game and for this bot alone share a queue.
+	pauseKey := routing.GameKey(routing.PauseKey, b.GameID, b.Username)
+	for _, key := range []string{routing.GameKey(routing.PauseKey, b.GameID), pauseKey} {
+		err = pubsub.SubscribeBrokerJSON(
+			conn,
+			routing.ExchangePerilDirect,
+			key,
+			pauseKey,
+			pubsub.QueueTypeTransient,
+			func(ps routing.PlayingState) pubsub.AckType {
+				b.state.SetPaused(ps.IsPaused)
+				return pubsub.AckTypeAck
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("Failed to subscribe to server pause state: %w", err)
+		}
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		routing.GameKey(routing.GameOverKey, b.GameID),
+		"",
+		pubsub.QueueTypeTransient,
+		func(over gamelogic.GameOver) pubsub.AckType {
+			switch over.Winner {
+			case "":
+				b.logf("the game ended in a draw: %s", over.Reason)
+			case b.Username:
+				b.logf("won the game: %s", over.Reason)
+			default:
+				b.logf("%s won the game: %s", over.Winner, over.Reason)
+			}
+			signal()
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to game over: %w", err)
+	}
+
+	err = pubsub.SubscribeBrokerJSON(
+		conn,
+		routing.ExchangePerilDirect,
+		fmt.Sprintf("%s.%s", routing.KickPrefix, b.Username),
+		"",
+		pubsub.QueueTypeTransient,
+		func(k routing.Kick) pubsub.AckType {
+			b.logf("kicked from the server: %s", k.Reason)
+			signal()
+			return pubsub.AckTypeAck
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("Failed to subscribe to kicks: %w", err)
+	}
+	return nil
+}
+
+func publishPresence(conn pubsub.Broker, pp routing.PlayerPresence, kind routing.PresenceKind) error {
+	pp.Kind = kind
+	pp.CurrentTime = time.Now()
+	return pubsub.PublishBrokerJSON(
+		conn,
+		routing.ExchangePerilTopic,
+		fmt.Sprintf("%s.%s", routing.PresencePrefix, pp.Username),
+		pp,
+	)
+}
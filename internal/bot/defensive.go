@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"slices"
+
+	"github.com/unappendixed/bootdevpubsub/internal/gamelogic"
+)
+
+// defensiveStrategy holds on to what it has. It buys the cheapest units, so
+// it always has plenty, in whichever of its territories borders a known
+// enemy, and only moves to reinforce those territories from safer ones
+// nearby. It never attacks.
+type defensiveStrategy struct{}
+
+func (defensiveStrategy) Name() string {
+	return "defensive"
+}
+
+func (defensiveStrategy) Plan(v View) [][]string {
+	commands := [][]string{}
+	board := v.Scenario.Board()
+
+	enemies := v.enemyLocations()
+	held := v.held()
+	threatened := []gamelogic.Location{}
+	for _, loc := range held {
+		for _, enemy := range enemies {
+			if board.Adjacent(loc, enemy) {
+				threatened = append(threatened, loc)
+				break
+			}
+		}
+	}
+
+	if affordable := v.affordable(); len(affordable) > 0 {
+		cheapest := affordable[len(affordable)-1]
+		var loc gamelogic.Location
+		switch {
+		case len(threatened) > 0:
+			loc = threatened[v.Rand.IntN(len(threatened))]
+		case len(held) > 0:
+			loc = held[v.Rand.IntN(len(held))]
+		default:
+			spawnable := v.spawnable()
+			if len(spawnable) > 0 {
+				loc = spawnable[v.Rand.IntN(len(spawnable))]
+			}
+		}
+		if loc != "" {
+			commands = append(commands, spawnCommand(loc, cheapest.Rank))
+		}
+	}
+
+	// Send half of each safe territory's units to a threatened neighbour,
+	// always leaving at least one behind to hold it.
+	groups := v.unitsByLocation()
+	for _, from := range sortedLocations(groups) {
+		ids := groups[from]
+		if slices.Contains(threatened, from) || len(ids) < 2 {
+			continue
+		}
+		for _, to := range threatened {
+			if board.Adjacent(from, to) {
+				commands = append(commands, moveCommand(to, ids[:len(ids)/2]))
+				break
+			}
+		}
+	}
+	return commands
+}
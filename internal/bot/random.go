@@ -0,0 +1,38 @@
+package bot
+
+// randomStrategy spawns and moves at random. It is a baseline for the others
+// to beat, and good at stumbling into wars.
+type randomStrategy struct{}
+
+func (randomStrategy) Name() string {
+	return "random"
+}
+
+func (randomStrategy) Plan(v View) [][]string {
+	commands := [][]string{}
+
+	affordable := v.affordable()
+	spawnable := v.spawnable()
+	if len(affordable) > 0 && len(spawnable) > 0 && v.Rand.IntN(2) == 0 {
+		ut := affordable[v.Rand.IntN(len(affordable))]
+		loc := spawnable[v.Rand.IntN(len(spawnable))]
+		commands = append(commands, spawnCommand(loc, ut.Rank))
+	}
+
+	groups := v.unitsByLocation()
+	held := sortedLocations(groups)
+	if len(held) == 0 || v.Rand.IntN(2) == 0 {
+		return commands
+	}
+	from := held[v.Rand.IntN(len(held))]
+	neighbours := v.Scenario.Board().Edges[from]
+	if len(neighbours) == 0 {
+		return commands
+	}
+	to := neighbours[v.Rand.IntN(len(neighbours))]
+	ids := groups[from]
+	v.Rand.Shuffle(len(ids), func(i, j int) {
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+	return append(commands, moveCommand(to, ids[:1+v.Rand.IntN(len(ids))]))
+}
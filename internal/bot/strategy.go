@@ -0,0 +1,188 @@
+package bot
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/unappendixed/bootdevpubsub/internal/gamelogic"
+)
+
+// Strategy decides what a bot does each time it gets to act.
+type Strategy interface {
+	Name() string
+	// Plan returns the commands to give, as the words a player would type
+	// into the client, e.g. []string{"spawn", "europe", "infantry"}. They
+	// are checked against the bot's state before being sent, so a plan may
+	// include commands that turn out to be invalid.
+	Plan(v View) [][]string
+}
+
+// View is everything a strategy knows about the game.
+type View struct {
+	Player   gamelogic.Player
+	Scenario *gamelogic.Scenario
+	// Enemies holds the other players' units where they were last seen.
+	// Fog of war means it is rarely the whole picture.
+	Enemies map[string][]gamelogic.Unit
+	Rand    *rand.Rand
+}
+
+var strategies = map[string]func() Strategy{
+	"random":     func() Strategy { return randomStrategy{} },
+	"aggressive": func() Strategy { return aggressiveStrategy{} },
+	"defensive":  func() Strategy { return defensiveStrategy{} },
+}
+
+// StrategyNames lists every strategy NewStrategy knows, sorted.
+func StrategyNames() []string {
+	names := []string{}
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewStrategy returns the strategy with the given name.
+func NewStrategy(name string) (Strategy, error) {
+	newStrategy, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q, pick one of %s", name, strings.Join(StrategyNames(), ", "))
+	}
+	return newStrategy(), nil
+}
+
+// affordable returns the unit types the player can pay for, strongest first.
+func (v View) affordable() []gamelogic.UnitType {
+	units := []gamelogic.UnitType{}
+	for _, ut := range v.Scenario.Units {
+		if ut.Cost <= v.Player.Treasury {
+			units = append(units, ut)
+		}
+	}
+	sort.SliceStable(units, func(i, j int) bool {
+		return units[i].Power > units[j].Power
+	})
+	return units
+}
+
+// held returns every location the player has units in, sorted.
+func (v View) held() []gamelogic.Location {
+	locations := []gamelogic.Location{}
+	for _, unit := range v.Player.Units {
+		if !slices.Contains(locations, unit.Location) {
+			locations = append(locations, unit.Location)
+		}
+	}
+	slices.Sort(locations)
+	return locations
+}
+
+// spawnable returns every location the player may spawn units in, sorted.
+func (v View) spawnable() []gamelogic.Location {
+	locations := v.held()
+	for _, info := range v.Scenario.Locations {
+		if v.Scenario.CanStartIn(info.Name) && !slices.Contains(locations, info.Name) {
+			locations = append(locations, info.Name)
+		}
+	}
+	slices.Sort(locations)
+	return locations
+}
+
+// enemyLocations returns every location an enemy unit was last seen in,
+// sorted.
+func (v View) enemyLocations() []gamelogic.Location {
+	locations := []gamelogic.Location{}
+	for _, units := range v.Enemies {
+		for _, unit := range units {
+			if !slices.Contains(locations, unit.Location) {
+				locations = append(locations, unit.Location)
+			}
+		}
+	}
+	slices.Sort(locations)
+	return locations
+}
+
+// unitsByLocation groups the IDs of the player's units that aren't already
+// travelling by where they are.
+func (v View) unitsByLocation() map[gamelogic.Location][]int {
+	groups := map[gamelogic.Location][]int{}
+	for _, unit := range v.Player.Units {
+		if len(unit.Path) > 0 {
+			continue
+		}
+		groups[unit.Location] = append(groups[unit.Location], unit.ID)
+	}
+	for _, ids := range groups {
+		slices.Sort(ids)
+	}
+	return groups
+}
+
+// distance is how many borders units at from must cross to reach to, or -1
+// if they can't.
+func (v View) distance(from gamelogic.Location, to gamelogic.Location) int {
+	path := v.Scenario.Board().Path(from, to)
+	if path == nil {
+		return -1
+	}
+	return len(path)
+}
+
+// nearest returns whichever of the targets is the fewest borders away from
+// loc, or false if none can be reached.
+func (v View) nearest(loc gamelogic.Location, targets []gamelogic.Location) (gamelogic.Location, bool) {
+	best := gamelogic.Location("")
+	bestDistance := -1
+	for _, target := range targets {
+		d := v.distance(loc, target)
+		if d < 0 {
+			continue
+		}
+		if bestDistance < 0 || d < bestDistance {
+			best, bestDistance = target, d
+		}
+	}
+	return best, bestDistance >= 0
+}
+
+// toward returns where to send units at loc to head for target: the target
+// itself if it borders loc or the board allows travel, otherwise the first
+// step on the way.
+func (v View) toward(loc gamelogic.Location, target gamelogic.Location) gamelogic.Location {
+	board := v.Scenario.Board()
+	if board.Travel {
+		return target
+	}
+	path := board.Path(loc, target)
+	if len(path) == 0 {
+		return loc
+	}
+	return path[0]
+}
+
+func sortedLocations(groups map[gamelogic.Location][]int) []gamelogic.Location {
+	locations := []gamelogic.Location{}
+	for loc := range groups {
+		locations = append(locations, loc)
+	}
+	slices.Sort(locations)
+	return locations
+}
+
+func spawnCommand(loc gamelogic.Location, rank gamelogic.UnitRank) []string {
+	return []string{"spawn", string(loc), string(rank)}
+}
+
+func moveCommand(loc gamelogic.Location, ids []int) []string {
+	words := []string{"move", string(loc)}
+	for _, id := range ids {
+		words = append(words, fmt.Sprint(id))
+	}
+	return words
+}
@@ -0,0 +1,89 @@
+package gamelogic
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Board is the map a game is played on: its locations and which of them
+// border each other. Units can only move between bordering locations unless
+// Travel is enabled, in which case they can be sent anywhere reachable and
+// advance one border per round.
+type Board struct {
+	Locations map[Location]struct{}
+	Edges     map[Location][]Location
+	Travel    bool
+}
+
+// NewBoard builds a board from a set of locations and undirected edges
+// between them.
+func NewBoard(locations map[Location]struct{}, edges [][2]Location) (Board, error) {
+	b := Board{
+		Locations: map[Location]struct{}{},
+		Edges:     map[Location][]Location{},
+	}
+	for loc := range locations {
+		b.Locations[loc] = struct{}{}
+	}
+	for _, edge := range edges {
+		for _, loc := range edge {
+			if !b.HasLocation(loc) {
+				return Board{}, fmt.Errorf("edge %s-%s references unknown location %s", edge[0], edge[1], loc)
+			}
+		}
+		if edge[0] == edge[1] {
+			return Board{}, fmt.Errorf("location %s can not border itself", edge[0])
+		}
+		if b.Adjacent(edge[0], edge[1]) {
+			continue
+		}
+		b.Edges[edge[0]] = append(b.Edges[edge[0]], edge[1])
+		b.Edges[edge[1]] = append(b.Edges[edge[1]], edge[0])
+	}
+	for loc := range b.Edges {
+		slices.Sort(b.Edges[loc])
+	}
+	return b, nil
+}
+
+func (b Board) HasLocation(loc Location) bool {
+	_, ok := b.Locations[loc]
+	return ok
+}
+
+func (b Board) Adjacent(from Location, to Location) bool {
+	return slices.Contains(b.Edges[from], to)
+}
+
+// Path returns the shortest list of locations to pass through to get from
+// one location to another, ending with the destination and not including
+// the start. It returns nil if the destination can't be reached.
+func (b Board) Path(from Location, to Location) []Location {
+	if from == to {
+		return []Location{}
+	}
+
+	previous := map[Location]Location{from: ""}
+	queue := []Location{from}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		// Edges are sorted, so ties are always broken the same way.
+		for _, next := range b.Edges[current] {
+			if _, seen := previous[next]; seen {
+				continue
+			}
+			previous[next] = current
+			if next == to {
+				path := []Location{}
+				for loc := to; loc != from; loc = previous[loc] {
+					path = append(path, loc)
+				}
+				slices.Reverse(path)
+				return path
+			}
+			queue = append(queue, next)
+		}
+	}
+	return nil
+}
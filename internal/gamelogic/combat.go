@@ -0,0 +1,173 @@
+package gamelogic
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+)
+
+const (
+	CombatClassic   = "classic"
+	CombatAttrition = "attrition"
+)
+
+// Battle is a fight between two players' units in a single location.
+type Battle struct {
+	Location  Location
+	Attackers []Unit
+	Defenders []Unit
+	Scenario  *Scenario
+	Rand      *rand.Rand
+}
+
+// BattleOutcome lists the power each side fought with and the IDs of the
+// units each side lost.
+type BattleOutcome struct {
+	AttackerPower      int
+	DefenderPower      int
+	AttackerCasualties []int
+	DefenderCasualties []int
+}
+
+// CombatResolver decides the outcome of a battle. Resolvers must only use
+// b.Rand for randomness so that a battle replayed with the same seed has the
+// same outcome.
+type CombatResolver interface {
+	Resolve(b Battle) BattleOutcome
+}
+
+// NewCombatResolver returns the resolver for one of the Combat* models.
+func NewCombatResolver(model string) (CombatResolver, error) {
+	switch model {
+	case "", CombatClassic:
+		return ClassicCombat{}, nil
+	case CombatAttrition:
+		return AttritionCombat{}, nil
+	default:
+		return nil, fmt.Errorf("unknown combat model %q", model)
+	}
+}
+
+// ClassicCombat is the original rule: the side with more total power wins
+// and the loser loses every unit. In a draw both sides are wiped out.
+type ClassicCombat struct{}
+
+func (ClassicCombat) Resolve(b Battle) BattleOutcome {
+	outcome := BattleOutcome{
+		AttackerPower: b.Scenario.power(b.Attackers),
+		DefenderPower: b.Scenario.power(b.Defenders),
+	}
+	if outcome.AttackerPower <= outcome.DefenderPower {
+		outcome.AttackerCasualties = unitIDs(b.Attackers)
+	}
+	if outcome.DefenderPower <= outcome.AttackerPower {
+		outcome.DefenderCasualties = unitIDs(b.Defenders)
+	}
+	return outcome
+}
+
+// AttritionCombat weighs each unit by how well it matches up against the
+// enemy, the terrain and a random roll, gives the defender a bonus, and
+// kills a share of each side proportional to the enemy's power. Every battle
+// costs the loser at least one unit, and both sides one in a draw.
+type AttritionCombat struct{}
+
+// Each unit's power is scaled by a random roll in [1-attritionRoll,
+// 1+attritionRoll].
+const attritionRoll = 0.2
+
+func (AttritionCombat) Resolve(b Battle) BattleOutcome {
+	terrain := b.Scenario.Terrain(b.Location)
+
+	attackerPower := 0.0
+	for _, unit := range sortedByID(b.Attackers) {
+		attackerPower += b.unitPower(unit, b.Defenders) * terrain.AttackMultiplier
+	}
+	defenderPower := 0.0
+	for _, unit := range sortedByID(b.Defenders) {
+		defenderPower += b.unitPower(unit, b.Attackers) * terrain.DefenseMultiplier * b.Scenario.defenderBonus()
+	}
+
+	outcome := BattleOutcome{
+		AttackerPower: int(math.Round(attackerPower)),
+		DefenderPower: int(math.Round(defenderPower)),
+	}
+
+	total := attackerPower + defenderPower
+	attackerShare, defenderShare := 0.5, 0.5
+	if total > 0 {
+		attackerShare = defenderPower / total
+		defenderShare = attackerPower / total
+	}
+	attackerLosses := int(math.Round(attackerShare * float64(len(b.Attackers))))
+	defenderLosses := int(math.Round(defenderShare * float64(len(b.Defenders))))
+
+	switch {
+	case outcome.AttackerPower > outcome.DefenderPower:
+		defenderLosses = max(defenderLosses, 1)
+	case outcome.DefenderPower > outcome.AttackerPower:
+		attackerLosses = max(attackerLosses, 1)
+	default:
+		attackerLosses = max(attackerLosses, 1)
+		defenderLosses = max(defenderLosses, 1)
+	}
+
+	outcome.AttackerCasualties = pickCasualties(b.Rand, b.Attackers, attackerLosses)
+	outcome.DefenderCasualties = pickCasualties(b.Rand, b.Defenders, defenderLosses)
+	return outcome
+}
+
+// unitPower is the unit's base power, scaled by its average matchup against
+// the enemy units and a random roll.
+func (b Battle) unitPower(unit Unit, enemies []Unit) float64 {
+	ut, _ := b.Scenario.UnitType(unit.Rank)
+	power := float64(ut.Power)
+
+	if len(enemies) > 0 {
+		matchup := 0.0
+		for _, enemy := range enemies {
+			matchup += b.Scenario.matchup(unit.Rank, enemy.Rank)
+		}
+		power *= matchup / float64(len(enemies))
+	}
+
+	roll := 1.0
+	if b.Rand != nil {
+		roll += (b.Rand.Float64()*2 - 1) * attritionRoll
+	}
+	return power * roll
+}
+
+// pickCasualties chooses n of units to die, at random if rng is set and by
+// lowest ID otherwise.
+func pickCasualties(rng *rand.Rand, units []Unit, n int) []int {
+	ids := unitIDs(units)
+	if n >= len(ids) {
+		return ids
+	}
+	if rng != nil {
+		rng.Shuffle(len(ids), func(i, j int) {
+			ids[i], ids[j] = ids[j], ids[i]
+		})
+	}
+	casualties := ids[:n]
+	slices.Sort(casualties)
+	return casualties
+}
+
+func sortedByID(units []Unit) []Unit {
+	sorted := slices.Clone(units)
+	slices.SortFunc(sorted, func(a, b Unit) int {
+		return a.ID - b.ID
+	})
+	return sorted
+}
+
+func unitIDs(units []Unit) []int {
+	ids := []int{}
+	for _, unit := range sortedByID(units) {
+		ids = append(ids, unit.ID)
+	}
+	return ids
+}
@@ -0,0 +1,89 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Diplomacy tells a player that another player has proposed, accepted or
+// broken an alliance with them. Kind is one of the diplomacy intents.
+type Diplomacy struct {
+	Kind IntentKind
+	From string
+	To   string
+}
+
+// CommandDiplomacy checks a propose, accept or break command against the
+// local state and returns the intent to send to the server.
+func (gs *GameState) CommandDiplomacy(words []string) (Intent, error) {
+	if len(words) != 2 {
+		return Intent{}, fmt.Errorf("usage: %s <username>", words[0])
+	}
+	kind := IntentKind(words[0])
+	other := words[1]
+	if other == gs.GetUsername() {
+		return Intent{}, errors.New("error: you can not make an alliance with yourself")
+	}
+
+	switch kind {
+	case IntentPropose, IntentAccept:
+		if gs.IsAlly(other) {
+			return Intent{}, fmt.Errorf("error: you are already allied with %s", other)
+		}
+	case IntentBreak:
+		if !gs.IsAlly(other) {
+			return Intent{}, fmt.Errorf("error: you are not allied with %s", other)
+		}
+	default:
+		return Intent{}, fmt.Errorf("error: %s is not a diplomacy command", kind)
+	}
+
+	return Intent{
+		GameID:   gs.GetGameID(),
+		Username: gs.GetUsername(),
+		Kind:     kind,
+		Player:   other,
+	}, nil
+}
+
+func (gs *GameState) HandleDiplomacy(d Diplomacy) {
+	if d.To != gs.GetUsername() {
+		return
+	}
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Diplomacy ====")
+	switch d.Kind {
+	case IntentPropose:
+		fmt.Printf("%s proposes an alliance. Use 'accept %s' to agree.\n", d.From, d.From)
+	case IntentAccept:
+		fmt.Printf("%s accepted your alliance. Your units can now share locations.\n", d.From)
+	case IntentBreak:
+		fmt.Printf("%s broke off your alliance!\n", d.From)
+	}
+}
+
+func (gs *GameState) IsAlly(username string) bool {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return slices.Contains(gs.Player.Allies, username)
+}
+
+func (gs *GameState) AddAlly(username string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if slices.Contains(gs.Player.Allies, username) {
+		return
+	}
+	gs.Player.Allies = append(gs.Player.Allies, username)
+	slices.Sort(gs.Player.Allies)
+}
+
+func (gs *GameState) RemoveAlly(username string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Player.Allies = slices.DeleteFunc(gs.Player.Allies, func(ally string) bool {
+		return ally == username
+	})
+}
@@ -0,0 +1,90 @@
+package gamelogic
+
+import (
+	"fmt"
+	"slices"
+)
+
+const (
+	defaultIncome           = 1
+	defaultStartingTreasury = 10
+)
+
+// Income is how much gold holding loc earns each tick.
+func (sc *Scenario) Income(loc Location) int {
+	income, ok := sc.incomes[loc]
+	if !ok {
+		return defaultIncome
+	}
+	return income
+}
+
+func (gs *GameState) GetTreasury() int {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Player.Treasury
+}
+
+func (gs *GameState) SetTreasury(gold int) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Player.Treasury = gold
+}
+
+// spend takes gold out of the treasury, or returns false without taking
+// anything if there isn't enough.
+func (gs *GameState) spend(gold int) bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.Player.Treasury < gold {
+		return false
+	}
+	gs.Player.Treasury -= gold
+	return true
+}
+
+// Territories returns every location the player has units in, in sorted
+// order.
+func (gs *GameState) Territories() []Location {
+	return territories(gs.GetPlayerSnap())
+}
+
+func territories(p Player) []Location {
+	locations := []Location{}
+	for _, unit := range p.Units {
+		if !slices.Contains(locations, unit.Location) {
+			locations = append(locations, unit.Location)
+		}
+	}
+	slices.Sort(locations)
+	return locations
+}
+
+// Income is how much the player's territories earn each tick.
+func (gs *GameState) Income() int {
+	sc := gs.GetScenario()
+	income := 0
+	for _, loc := range gs.Territories() {
+		income += sc.Income(loc)
+	}
+	return income
+}
+
+// CollectIncome pays the player for every territory they hold and returns
+// how much they earned.
+func (gs *GameState) CollectIncome() int {
+	income := gs.Income()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Player.Treasury += income
+	return income
+}
+
+func (gs *GameState) canAfford(rank UnitRank) error {
+	ut, _ := gs.GetScenario().UnitType(rank)
+	treasury := gs.GetTreasury()
+	if ut.Cost > treasury {
+		return fmt.Errorf("error: a(n) %s costs %v gold and you only have %v", rank, ut.Cost, treasury)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package gamelogic
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type EventKind string
+
+const (
+	EventStart     EventKind = "start"
+	EventJoin      EventKind = "join"
+	EventSpawn     EventKind = "spawn"
+	EventMove      EventKind = "move"
+	EventWar       EventKind = "war"
+	EventWarResult EventKind = "war_result"
+	EventIncome    EventKind = "income"
+	EventPause     EventKind = "pause"
+	EventResume    EventKind = "resume"
+	EventAlliance  EventKind = "alliance"
+	EventBreak     EventKind = "break"
+	EventGameOver  EventKind = "game_over"
+)
+
+// Event is one change to a game, as recorded by the server. Only the fields
+// for its kind are set. Replaying every event in order rebuilds the game.
+type Event struct {
+	Seq      int
+	Time     time.Time
+	GameID   string
+	Kind     EventKind
+	Username string `json:",omitempty"`
+	// Start
+	Scenario *Scenario `json:",omitempty"`
+	Seed     uint64    `json:",omitempty"`
+	// Join, spawn and income: the gold the player got or spent.
+	Gold int `json:",omitempty"`
+	// Spawn
+	Unit *Unit `json:",omitempty"`
+	// Move
+	Move *ArmyMove `json:",omitempty"`
+	// War and war result
+	War       *RecognitionOfWar `json:",omitempty"`
+	WarResult *WarResult        `json:",omitempty"`
+	// Alliance and break
+	Ally string `json:",omitempty"`
+	// Game over
+	GameOver *GameOver `json:",omitempty"`
+}
+
+func (e Event) String() string {
+	prefix := fmt.Sprintf("#%v %s", e.Seq, e.Time.Format(time.TimeOnly))
+	switch e.Kind {
+	case EventStart:
+		return fmt.Sprintf("%s game %s started on %s, seed %v", prefix, e.GameID, e.Scenario.Name, e.Seed)
+	case EventJoin:
+		return fmt.Sprintf("%s %s joined with %v gold", prefix, e.Username, e.Gold)
+	case EventSpawn:
+		return fmt.Sprintf("%s %s spawned %s %v in %s for %v gold", prefix, e.Username, e.Unit.Rank, e.Unit.ID, e.Unit.Location, e.Gold)
+	case EventMove:
+		return fmt.Sprintf("%s %s moved %v unit(s) to %s", prefix, e.Username, len(e.Move.Units), e.Move.ToLocation)
+	case EventWar:
+		return fmt.Sprintf("%s %s went to war with %s", prefix, e.War.Attacker.Username, e.War.Defender.Username)
+	case EventWarResult:
+		if e.WarResult.Draw {
+			return fmt.Sprintf("%s the war in %s ended in a draw", prefix, e.WarResult.Location)
+		}
+		return fmt.Sprintf("%s %s won the war in %s", prefix, e.WarResult.Winner, e.WarResult.Location)
+	case EventIncome:
+		return fmt.Sprintf("%s %s earned %v gold", prefix, e.Username, e.Gold)
+	case EventPause, EventResume:
+		if e.Username == "" {
+			return fmt.Sprintf("%s the game %sd", prefix, e.Kind)
+		}
+		return fmt.Sprintf("%s %s was %sd", prefix, e.Username, e.Kind)
+	case EventAlliance:
+		return fmt.Sprintf("%s %s and %s formed an alliance", prefix, e.Username, e.Ally)
+	case EventBreak:
+		return fmt.Sprintf("%s %s broke off their alliance with %s", prefix, e.Username, e.Ally)
+	case EventGameOver:
+		return fmt.Sprintf("%s game over: %s", prefix, e.GameOver.Reason)
+	}
+	return fmt.Sprintf("%s %s", prefix, e.Kind)
+}
+
+// EventLog appends events to a file, one JSON object per line, numbering
+// them as it goes.
+type EventLog struct {
+	f   *os.File
+	seq int
+	mu  *sync.Mutex
+}
+
+// OpenEventLog opens the log at path for appending, carrying on from the
+// last sequence number already in it.
+func OpenEventLog(path string) (*EventLog, error) {
+	events, err := ReadEvents(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open event log: %v", err)
+	}
+	el := &EventLog{f: f, mu: &sync.Mutex{}}
+	if len(events) > 0 {
+		el.seq = events[len(events)-1].Seq
+	}
+	return el, nil
+}
+
+// Append numbers and timestamps the event and writes it to the log.
+func (el *EventLog) Append(e Event) (Event, error) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+	el.seq++
+	e.Seq = el.seq
+	e.Time = time.Now()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return e, fmt.Errorf("could not encode event: %v", err)
+	}
+	_, err = el.f.Write(append(data, '\n'))
+	if err != nil {
+		return e, fmt.Errorf("could not write event: %v", err)
+	}
+	return e, nil
+}
+
+func (el *EventLog) Close() error {
+	return el.f.Close()
+}
+
+// ReadEvents reads every event in the log at path, in order.
+func ReadEvents(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events := []Event{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		e := Event{}
+		err := json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event after #%v: %v", len(events), err)
+		}
+		if e.Scenario != nil {
+			err = e.Scenario.init()
+			if err != nil {
+				return nil, fmt.Errorf("invalid scenario in event #%v: %v", e.Seq, err)
+			}
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read event log: %v", err)
+	}
+	return events, nil
+}
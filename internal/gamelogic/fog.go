@@ -0,0 +1,65 @@
+package gamelogic
+
+import "slices"
+
+// VisibleMove returns what viewer can see of a move. Players only see units
+// arriving in or next to a location they hold, and allies see each other's
+// moves wherever they are. Either way the viewer sees where the units moved
+// to, since that is where they show up, but not their paths beyond it or the
+// rest of the mover's state. It returns false if the viewer can't see the
+// move at all.
+func VisibleMove(move ArmyMove, viewer Player, board Board) (ArmyMove, bool) {
+	if !slices.Contains(viewer.Allies, move.Player.Username) && !canSee(viewer, move.ToLocation, board) {
+		return ArmyMove{}, false
+	}
+	return move.Redacted(), true
+}
+
+// Redacted strips a move down to what anyone watching the destination could
+// see: the mover's units there, without their paths. It is what spectators
+// are sent.
+func (move ArmyMove) Redacted() ArmyMove {
+	units := []Unit{}
+	for _, unit := range move.Units {
+		unit.Path = nil
+		units = append(units, unit)
+	}
+	return ArmyMove{
+		Player:     redactPlayer(move.Player, move.ToLocation),
+		Units:      units,
+		ToLocation: move.ToLocation,
+	}
+}
+
+// Redacted strips everything from a war that wasn't in plain sight in the
+// location it was fought in.
+func (rw RecognitionOfWar) Redacted(loc Location) RecognitionOfWar {
+	redacted := RecognitionOfWar{
+		Attacker: redactPlayer(rw.Attacker, loc),
+		Defender: redactPlayer(rw.Defender, loc),
+	}
+	for _, ally := range rw.DefenderAllies {
+		redacted.DefenderAllies = append(redacted.DefenderAllies, redactPlayer(ally, loc))
+	}
+	return redacted
+}
+
+// canSee reports whether the player holds loc or a location bordering it.
+func canSee(p Player, loc Location, board Board) bool {
+	for _, held := range territories(p) {
+		if held == loc || board.Adjacent(held, loc) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactPlayer keeps only the player's units in loc, without their paths.
+func redactPlayer(p Player, loc Location) Player {
+	units := map[int]Unit{}
+	for _, unit := range unitsInLocation(p, loc) {
+		unit.Path = nil
+		units[unit.ID] = unit
+	}
+	return Player{Username: p.Username, Units: units}
+}
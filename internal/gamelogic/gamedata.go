@@ -3,6 +3,10 @@ package gamelogic
 type Player struct {
 	Username string
 	Units    map[int]Unit
+	// Treasury is the gold the player has to spend on units.
+	Treasury int
+	// Allies are the players this player has an alliance with, sorted.
+	Allies []string
 }
 
 type UnitRank string
@@ -17,6 +21,9 @@ type Unit struct {
 	ID       int
 	Rank     UnitRank
 	Location Location
+	// Path holds the locations a travelling unit still has to pass
+	// through, ending with its destination.
+	Path []Location
 }
 
 type ArmyMove struct {
@@ -28,18 +35,55 @@ type ArmyMove struct {
 type RecognitionOfWar struct {
 	Attacker Player
 	Defender Player
+	// DefenderAllies are the defender's allies. Any with units where the
+	// war is fought defend alongside the defender.
+	DefenderAllies []Player
 }
 
-type Location string
+type IntentKind string
 
-func getAllRanks() map[UnitRank]struct{} {
-	return map[UnitRank]struct{}{
-		RankInfantry:  {},
-		RankCavalry:   {},
-		RankArtillery: {},
-	}
+const (
+	IntentSpawn IntentKind = "spawn"
+	IntentMove  IntentKind = "move"
+	// Diplomacy intents, see Diplomacy.
+	IntentPropose IntentKind = "propose"
+	IntentAccept  IntentKind = "accept"
+	IntentBreak   IntentKind = "break"
+)
+
+// Intent is a command a client asks the server to carry out on its behalf.
+type Intent struct {
+	// GameID is the game the intent is for.
+	GameID   string
+	Username string
+	Kind     IntentKind
+	Location Location
+	Rank     UnitRank
+	UnitIDs  []int
+	// Player is the other player in a diplomacy intent.
+	Player string
+}
+
+// GameInfo tells a client which game it has joined. The server sends it
+// whenever a player joins.
+type GameInfo struct {
+	GameID   string
+	Scenario *Scenario
+	Seed     uint64
+	// Turn is the current turn, or nil if the game is played in real time.
+	Turn *Turn
 }
 
+// StateUpdate is the server's authoritative view of a player, sent whenever
+// their state changes or one of their intents is rejected.
+type StateUpdate struct {
+	Player  Player
+	Message string
+	Error   string
+}
+
+type Location string
+
 func getAllLocations() map[Location]struct{} {
 	return map[Location]struct{}{
 		"americas":   {},
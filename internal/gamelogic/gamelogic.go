@@ -4,9 +4,13 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
-	"math/rand"
+	"math/rand/v2"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/unappendixed/bootdevpubsub/internal/routing"
 )
 
 func PrintClientHelp() {
@@ -17,7 +21,12 @@ func PrintClientHelp() {
 	fmt.Println("* spawn <location> <rank>")
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
+	fmt.Println("* propose <username>")
+	fmt.Println("* accept <username>")
+	fmt.Println("* break <username>")
 	fmt.Println("* status")
+	fmt.Println("* map")
+	fmt.Println("* save [file]")
 	fmt.Println("* spam <n>")
 	fmt.Println("    example:")
 	fmt.Println("    spam 5")
@@ -38,10 +47,143 @@ func ClientWelcome() (string, error) {
 	return username, nil
 }
 
+// LobbyChoice is what a player picked in the lobby: a game to join or
+// watch, a match to wait for, or, if none of those are set, a new game.
+type LobbyChoice struct {
+	GameID string
+	// Spectate is set if the player only wants to watch the game.
+	Spectate bool
+	Match    *routing.MatchRequest
+	// Load is set if the player wants to pick up a game they saved, from
+	// SaveFile or their default save if that is empty.
+	Load     bool
+	SaveFile string
+}
+
+// ClientPickGame asks the player which game to join or watch, whether to
+// start a new one or load a saved one, or whether to wait for matchmaking to
+// find them one.
+func ClientPickGame(username string, games []routing.GameSummary) (LobbyChoice, error) {
+	open := map[string]struct{}{}
+	if len(games) == 0 {
+		fmt.Println("No games are running.")
+	} else {
+		fmt.Println("Games:")
+	}
+	for _, gs := range games {
+		if gs.Over {
+			continue
+		}
+		open[gs.GameID] = struct{}{}
+		fmt.Printf("* %s: %s, %d player(s)\n", gs.GameID, gs.Map, gs.Players)
+	}
+	fmt.Println("Enter a game ID to join it, \"watch <game>\" to spectate it, \"new\" to")
+	fmt.Println("start a new game, \"load [file]\" to pick up a game you saved, or")
+	fmt.Println("\"match [players] [map]\" to be matched with other players:")
+	for {
+		words := GetInput()
+		if words == nil {
+			return LobbyChoice{}, errors.New("you must pick a game. goodbye")
+		}
+		if len(words) == 0 {
+			continue
+		}
+		switch words[0] {
+		case "new":
+			return LobbyChoice{}, nil
+		case "load":
+			if len(words) > 2 {
+				fmt.Println("usage: load [file]")
+				continue
+			}
+			choice := LobbyChoice{Load: true}
+			if len(words) == 2 {
+				choice.SaveFile = words[1]
+			}
+			return choice, nil
+		case "match":
+			match, err := parseMatchRequest(username, words)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			return LobbyChoice{Match: &match}, nil
+		case "watch":
+			if len(words) != 2 {
+				fmt.Println("usage: watch <game>")
+				continue
+			}
+			if _, ok := open[words[1]]; !ok {
+				fmt.Printf("There is no game %q to watch\n", words[1])
+				continue
+			}
+			return LobbyChoice{GameID: words[1], Spectate: true}, nil
+		}
+		if _, ok := open[words[0]]; ok {
+			return LobbyChoice{GameID: words[0]}, nil
+		}
+		fmt.Printf("There is no game %q to join\n", words[0])
+	}
+}
+
+func parseMatchRequest(username string, words []string) (routing.MatchRequest, error) {
+	req := routing.MatchRequest{Username: username}
+	if len(words) > 3 {
+		return req, errors.New("usage: match [players] [map]")
+	}
+	if len(words) > 1 {
+		players, err := strconv.Atoi(words[1])
+		if err != nil || players < 2 {
+			return req, errors.New("error: players must be a number of at least 2")
+		}
+		req.Players = players
+	}
+	if len(words) > 2 {
+		req.Map = words[2]
+	}
+	return req, nil
+}
+
+func PrintSpectatorHelp() {
+	fmt.Println("You are spectating. Possible commands:")
+	fmt.Println("* status")
+	fmt.Println("* map")
+	fmt.Println("* quit")
+	fmt.Println("* help")
+}
+
 func PrintServerHelp() {
 	fmt.Println("Possible commands:")
-	fmt.Println("* pause")
-	fmt.Println("* resume")
+	fmt.Println("* games")
+	fmt.Println("* matchmaking")
+	fmt.Println("* create [map file]")
+	fmt.Println("* use <game>")
+	fmt.Println("* close <game>")
+	fmt.Println("* pause [username]")
+	fmt.Println("* resume [username]")
+	fmt.Println("* players")
+	fmt.Println("* standings")
+	fmt.Println("* leaderboard [n]")
+	fmt.Println("* save [file]")
+	fmt.Println("* announce <message>")
+	fmt.Println("    example:")
+	fmt.Println("    announce server restarting in 5 minutes")
+	fmt.Println("* kick <username> [reason]")
+	fmt.Println("* logs [n]")
+	fmt.Println("* queues")
+	fmt.Println("* quit")
+	fmt.Println("* help")
+}
+
+func PrintReplayHelp() {
+	fmt.Println("Possible commands:")
+	fmt.Println("* next [n]")
+	fmt.Println("* back [n]")
+	fmt.Println("* seek <seq>")
+	fmt.Println("* players")
+	fmt.Println("* status <username>")
+	fmt.Println("* standings")
+	fmt.Println("* map")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
@@ -58,7 +200,7 @@ func GetInput() []string {
 	return strings.Fields(line)
 }
 
-func GetMaliciousLog() string {
+func GetMaliciousLog(rng *rand.Rand) string {
 	possibleLogs := []string{
 		"Never interrupt your enemy when he is making a mistake.",
 		"The hardest thing of all for a soldier is to retreat.",
@@ -67,7 +209,7 @@ func GetMaliciousLog() string {
 		"The art of war is simple enough. Find out where your enemy is. Get at him as soon as you can. Strike him as hard as you can, and keep moving on.",
 		"All warfare is based on deception.",
 	}
-	randomIndex := rand.Intn(len(possibleLogs))
+	randomIndex := rng.IntN(len(possibleLogs))
 	msg := possibleLogs[randomIndex]
 	return msg
 }
@@ -83,10 +225,70 @@ func (gs *GameState) CommandStatus() {
 	} else {
 		fmt.Println("The game is not paused.")
 	}
+	if turn, ok := gs.GetTurn(); ok {
+		fmt.Printf("It is turn %v; orders are due by %s.\n", turn.Number, turn.Deadline.Format(time.TimeOnly))
+	}
 
 	p := gs.GetPlayerSnap()
 	fmt.Printf("You are %s, and you have %d units.\n", p.Username, len(p.Units))
+	fmt.Printf("Your treasury holds %v gold, and your territories earn %v gold per tick.\n", p.Treasury, gs.Income())
+	if len(p.Allies) > 0 {
+		fmt.Printf("You are allied with %v.\n", p.Allies)
+	}
 	for _, unit := range p.Units {
+		if len(unit.Path) > 0 {
+			fmt.Printf("* %v: %v, %v (travelling to %v)\n", unit.ID, unit.Location, unit.Rank, unit.Path[len(unit.Path)-1])
+			continue
+		}
 		fmt.Printf("* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
 	}
 }
+
+func (gs *GameState) CommandMap() {
+	printMap(gs.GetScenario())
+}
+
+func printMap(sc *Scenario) {
+	board := sc.Board()
+	fmt.Printf("Map: %s (%s combat)\n", sc.Name, sc.Combat)
+	for _, info := range sc.Locations {
+		line := fmt.Sprintf("* %s", info.Name)
+		if info.Terrain != "" {
+			line += fmt.Sprintf(" (%s)", info.Terrain)
+		}
+		line += fmt.Sprintf(" +%v gold", sc.Income(info.Name))
+		if sc.CanStartIn(info.Name) && len(sc.StartingTerritories) > 0 {
+			line += " [start]"
+		}
+		fmt.Printf("%s, borders: %v\n", line, board.Edges[info.Name])
+	}
+	if board.Travel {
+		fmt.Println("Units can travel to any reachable location, one border per round.")
+	}
+	fmt.Printf("Players start with %v gold.\n", sc.StartingTreasury)
+	fmt.Println("Units:")
+	for _, ut := range sc.Units {
+		fmt.Printf("* %s: power %v, cost %v\n", ut.Rank, ut.Power, ut.Cost)
+	}
+	printVictory(sc)
+}
+
+func printVictory(sc *Scenario) {
+	fmt.Println("To win:")
+	won := false
+	if sc.Victory.ControlLocations > 0 {
+		fmt.Printf("* control %v locations\n", sc.Victory.ControlLocations)
+		won = true
+	}
+	if sc.Victory.Elimination {
+		fmt.Println("* be the last player standing")
+		won = true
+	}
+	if sc.TimeLimit() > 0 {
+		fmt.Printf("* have the highest score after %v\n", sc.TimeLimit())
+		won = true
+	}
+	if !won {
+		fmt.Println("* nobody can win this game, it goes on forever")
+	}
+}
@@ -1,13 +1,27 @@
 package gamelogic
 
 import (
+	"slices"
 	"sync"
 )
 
 type GameState struct {
+	// GameID identifies the game the player is in, so saves from one game
+	// can't be loaded into another.
+	GameID string
 	Player Player
 	Paused bool
-	mu     *sync.RWMutex
+	// NextUnitID is the ID the next spawned unit will get. IDs are never
+	// reused, even after the unit they belonged to is killed.
+	NextUnitID int
+	// Seed is shared by every player in a game. All randomness comes from
+	// it through Rand, so replaying the same events gives the same results.
+	Seed uint64
+	// RandDraws counts the random streams handed out by NextRandStream.
+	RandDraws uint64
+	scenario  *Scenario
+	turn      *Turn
+	mu        *sync.RWMutex
 }
 
 func NewGameState(username string) *GameState {
@@ -16,11 +30,29 @@ func NewGameState(username string) *GameState {
 			Username: username,
 			Units:    map[int]Unit{},
 		},
-		Paused: false,
-		mu:     &sync.RWMutex{},
+		Paused:     false,
+		NextUnitID: 1,
+		scenario:   DefaultScenario(),
+		mu:         &sync.RWMutex{},
 	}
 }
 
+func (gs *GameState) SetScenario(sc *Scenario) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.scenario = sc
+}
+
+func (gs *GameState) GetScenario() *Scenario {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.scenario
+}
+
+func (gs *GameState) GetBoard() Board {
+	return gs.GetScenario().Board()
+}
+
 func (gs *GameState) resumeGame() {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
@@ -45,16 +77,46 @@ func (gs *GameState) addUnit(u Unit) {
 	gs.Player.Units[u.ID] = u
 }
 
-func (gs *GameState) removeUnitsInLocation(loc Location) {
+// allocateUnitID returns a unit ID that has never been handed out in this
+// game.
+func (gs *GameState) allocateUnitID() int {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	for k, v := range gs.Player.Units {
-		if v.Location == loc {
-			delete(gs.Player.Units, k)
+	if gs.NextUnitID < 1 {
+		gs.NextUnitID = 1
+	}
+	for {
+		id := gs.NextUnitID
+		gs.NextUnitID++
+		if _, ok := gs.Player.Units[id]; !ok {
+			return id
 		}
 	}
 }
 
+func (gs *GameState) removeUnit(id int) bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if _, ok := gs.Player.Units[id]; !ok {
+		return false
+	}
+	delete(gs.Player.Units, id)
+	return true
+}
+
+// setPlayer replaces the player's units with those in p.
+func (gs *GameState) setPlayer(p Player) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	units := map[int]Unit{}
+	for k, v := range p.Units {
+		units[k] = v
+	}
+	gs.Player.Units = units
+	gs.Player.Treasury = p.Treasury
+	gs.Player.Allies = slices.Clone(p.Allies)
+}
+
 func (gs *GameState) UpdateUnit(u Unit) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
@@ -75,6 +137,17 @@ func (gs *GameState) getUnitsSnap() []Unit {
 	return Units
 }
 
+func (gs *GameState) hasUnitsIn(loc Location) bool {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	for _, u := range gs.Player.Units {
+		if u.Location == loc {
+			return true
+		}
+	}
+	return false
+}
+
 func (gs *GameState) GetUnit(id int) (Unit, bool) {
 	gs.mu.RLock()
 	defer gs.mu.RUnlock()
@@ -92,5 +165,7 @@ func (gs *GameState) GetPlayerSnap() Player {
 	return Player{
 		Username: gs.Player.Username,
 		Units:    Units,
+		Treasury: gs.Player.Treasury,
+		Allies:   slices.Clone(gs.Player.Allies),
 	}
 }
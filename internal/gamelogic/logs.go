@@ -1,9 +1,12 @@
 package gamelogic
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/unappendixed/bootdevpubsub/internal/routing"
@@ -30,3 +33,53 @@ func WriteLog(gamelog routing.GameLog) error {
 	}
 	return nil
 }
+
+// ReadRecentLogs returns up to the last n entries written by WriteLog,
+// oldest first.
+func ReadRecentLogs(n int) ([]routing.GameLog, error) {
+	f, err := os.Open(logsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return []routing.GameLog{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open logs file: %v", err)
+	}
+	defer f.Close()
+
+	logs := []routing.GameLog{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		gamelog, ok := parseLogLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		logs = append(logs, gamelog)
+		if len(logs) > n {
+			logs = logs[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read logs file: %v", err)
+	}
+	return logs, nil
+}
+
+func parseLogLine(line string) (routing.GameLog, bool) {
+	timestamp, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return routing.GameLog{}, false
+	}
+	currentTime, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return routing.GameLog{}, false
+	}
+	username, message, ok := strings.Cut(rest, ": ")
+	if !ok {
+		return routing.GameLog{}, false
+	}
+	return routing.GameLog{
+		CurrentTime: currentTime,
+		Username:    username,
+		Message:     message,
+	}, true
+}
@@ -3,6 +3,7 @@ package gamelogic
 import (
 	"errors"
 	"fmt"
+	"slices"
 	"strconv"
 )
 
@@ -12,6 +13,7 @@ const (
 	MoveOutcomeSamePlayer MoveOutcome = iota
 	MoveOutComeSafe
 	MoveOutcomeMakeWar
+	MoveOutcomeInvalid
 )
 
 func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
@@ -28,6 +30,16 @@ func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
 	if player.Username == move.Player.Username {
 		return MoveOutcomeSamePlayer
 	}
+	if gs.IsAlly(move.Player.Username) {
+		fmt.Printf("%s is your ally.\n", move.Player.Username)
+		return MoveOutComeSafe
+	}
+
+	err := ValidateMove(move)
+	if err != nil {
+		fmt.Printf("Ignoring invalid move from %s: %v\n", move.Player.Username, err)
+		return MoveOutcomeInvalid
+	}
 
 	overlappingLocation := getOverlappingLocation(player, move.Player)
 	if overlappingLocation != "" {
@@ -38,53 +50,186 @@ func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
 	return MoveOutComeSafe
 }
 
+// getOverlappingLocation returns the first location, in sorted order, where
+// both players have units. Sorting keeps the answer the same on every client
+// regardless of map iteration order.
 func getOverlappingLocation(p1 Player, p2 Player) Location {
+	p2Locations := map[Location]struct{}{}
+	for _, u2 := range p2.Units {
+		p2Locations[u2.Location] = struct{}{}
+	}
+
+	overlapping := []Location{}
 	for _, u1 := range p1.Units {
-		for _, u2 := range p2.Units {
-			if u1.Location == u2.Location {
-				return u1.Location
-			}
+		if _, ok := p2Locations[u1.Location]; ok {
+			overlapping = append(overlapping, u1.Location)
+		}
+	}
+	if len(overlapping) == 0 {
+		return ""
+	}
+	slices.Sort(overlapping)
+	return overlapping[0]
+}
+
+// ValidateMove checks that every unit in a move has a unique ID, belongs to
+// the moving player and has arrived at the destination.
+func ValidateMove(move ArmyMove) error {
+	seen := map[int]struct{}{}
+	for _, unit := range move.Units {
+		if _, ok := seen[unit.ID]; ok {
+			return fmt.Errorf("unit ID %v appears more than once", unit.ID)
+		}
+		seen[unit.ID] = struct{}{}
+
+		owned, ok := move.Player.Units[unit.ID]
+		if !ok {
+			return fmt.Errorf("unit ID %v does not belong to %s", unit.ID, move.Player.Username)
+		}
+		if owned.Rank != unit.Rank || owned.Location != unit.Location {
+			return fmt.Errorf("unit ID %v does not match %s's units", unit.ID, move.Player.Username)
+		}
+		if unit.Location != move.ToLocation {
+			return fmt.Errorf("unit ID %v is not in %s", unit.ID, move.ToLocation)
 		}
 	}
-	return ""
+	return nil
 }
 
-func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
+// CommandMove checks a move command against the local state and returns the
+// intent to send to the server. Whether the destination can be reached is
+// left to the server.
+func (gs *GameState) CommandMove(words []string) (Intent, error) {
 	if gs.isPaused() {
-		return ArmyMove{}, errors.New("the game is paused, you can not move units")
+		return Intent{}, errors.New("the game is paused, you can not move units")
 	}
 	if len(words) < 3 {
-		return ArmyMove{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
+		return Intent{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
 	}
 	newLocation := Location(words[1])
-	locations := getAllLocations()
-	if _, ok := locations[newLocation]; !ok {
-		return ArmyMove{}, fmt.Errorf("error: %s is not a valid location", newLocation)
+	if !gs.GetBoard().HasLocation(newLocation) {
+		return Intent{}, fmt.Errorf("error: %s is not a valid location", newLocation)
 	}
 	unitIDs := []int{}
 	for _, word := range words[2:] {
 		id := word
 		unitID, err := strconv.Atoi(id)
 		if err != nil {
-			return ArmyMove{}, fmt.Errorf("error: %s is not a valid unit ID", id)
+			return Intent{}, fmt.Errorf("error: %s is not a valid unit ID", id)
 		}
 		unitIDs = append(unitIDs, unitID)
 	}
 
 	for _, unitID := range unitIDs {
+		if _, ok := gs.GetUnit(unitID); !ok {
+			return Intent{}, fmt.Errorf("error: unit with ID %v not found", unitID)
+		}
+	}
+
+	return Intent{
+		GameID:   gs.GetGameID(),
+		Username: gs.GetUsername(),
+		Kind:     IntentMove,
+		Location: newLocation,
+		UnitIDs:  unitIDs,
+	}, nil
+}
+
+// ApplyMove validates a move intent and moves the units. Units bordering the
+// destination go straight there. If the board allows travel, the rest set
+// off along the shortest path and take their first step now. It returns one
+// move per location units arrived in.
+func (gs *GameState) ApplyMove(intent Intent) ([]ArmyMove, error) {
+	if gs.isPaused() {
+		return nil, errors.New("the game is paused, you can not move units")
+	}
+	board := gs.GetBoard()
+	if !board.HasLocation(intent.Location) {
+		return nil, fmt.Errorf("error: %s is not a valid location", intent.Location)
+	}
+	if len(intent.UnitIDs) == 0 {
+		return nil, errors.New("error: no units to move")
+	}
+
+	units := []Unit{}
+	seen := map[int]struct{}{}
+	for _, unitID := range intent.UnitIDs {
+		if _, ok := seen[unitID]; ok {
+			return nil, fmt.Errorf("error: unit with ID %v listed more than once", unitID)
+		}
+		seen[unitID] = struct{}{}
 		unit, ok := gs.GetUnit(unitID)
 		if !ok {
-			return ArmyMove{}, fmt.Errorf("error: unit with ID %v not found", unitID)
+			return nil, fmt.Errorf("error: unit with ID %v not found", unitID)
+		}
+
+		switch {
+		case unit.Location == intent.Location:
+			unit.Path = nil
+		case board.Adjacent(unit.Location, intent.Location):
+			unit.Location = intent.Location
+			unit.Path = nil
+		case board.Travel:
+			path := board.Path(unit.Location, intent.Location)
+			if path == nil {
+				return nil, fmt.Errorf("error: unit %v can not reach %s from %s", unitID, intent.Location, unit.Location)
+			}
+			unit.Location = path[0]
+			unit.Path = path[1:]
+		default:
+			return nil, fmt.Errorf("error: %s does not border %s, where unit %v is", intent.Location, unit.Location, unitID)
+		}
+		units = append(units, unit)
+	}
+	for _, unit := range units {
+		gs.UpdateUnit(unit)
+	}
+
+	return gs.arrivals(units), nil
+}
+
+// AdvanceTravel moves every travelling unit one step further along its path
+// and returns one move per location units arrived in.
+func (gs *GameState) AdvanceTravel() []ArmyMove {
+	units := []Unit{}
+	for _, unit := range gs.getUnitsSnap() {
+		if len(unit.Path) == 0 {
+			continue
+		}
+		unit.Location = unit.Path[0]
+		unit.Path = unit.Path[1:]
+		if len(unit.Path) == 0 {
+			unit.Path = nil
 		}
-		unit.Location = newLocation
 		gs.UpdateUnit(unit)
+		units = append(units, unit)
 	}
+	return gs.arrivals(units)
+}
 
-	mv := ArmyMove{
-		ToLocation: newLocation,
-		Units:      gs.getUnitsSnap(),
-		Player:     gs.GetPlayerSnap(),
+// arrivals groups units by the location they are now in, in sorted order.
+func (gs *GameState) arrivals(units []Unit) []ArmyMove {
+	slices.SortFunc(units, func(a, b Unit) int {
+		return a.ID - b.ID
+	})
+	byLocation := map[Location][]Unit{}
+	locations := []Location{}
+	for _, unit := range units {
+		if _, ok := byLocation[unit.Location]; !ok {
+			locations = append(locations, unit.Location)
+		}
+		byLocation[unit.Location] = append(byLocation[unit.Location], unit)
+	}
+	slices.Sort(locations)
+
+	player := gs.GetPlayerSnap()
+	moves := []ArmyMove{}
+	for _, loc := range locations {
+		moves = append(moves, ArmyMove{
+			ToLocation: loc,
+			Units:      byLocation[loc],
+			Player:     player,
+		})
 	}
-	fmt.Printf("Moved %v units to %s\n", len(mv.Units), mv.ToLocation)
-	return mv, nil
+	return moves
 }
@@ -17,3 +17,17 @@ func (gs *GameState) HandlePause(ps routing.PlayingState) {
 		gs.resumeGame()
 	}
 }
+
+func (gs *GameState) IsPaused() bool {
+	return gs.isPaused()
+}
+
+// SetPaused pauses or resumes the game without printing anything, for use on
+// the server.
+func (gs *GameState) SetPaused(paused bool) {
+	if paused {
+		gs.pauseGame()
+	} else {
+		gs.resumeGame()
+	}
+}
@@ -0,0 +1,50 @@
+package gamelogic
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+)
+
+// GameRand returns the random source for one stream of a game. Every random
+// decision draws from its own stream, so anyone who knows the game's seed
+// and the stream number can reproduce it exactly.
+func GameRand(seed uint64, stream uint64) *rand.Rand {
+	return rand.New(rand.NewPCG(seed, stream))
+}
+
+// NextRandStream hands out the player's next unused stream of the game's
+// seed.
+func (gs *GameState) NextRandStream() uint64 {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	stream := playerStream(gs.Player.Username) + gs.RandDraws
+	gs.RandDraws++
+	return stream
+}
+
+// Rand returns a random source for the player's next stream.
+func (gs *GameState) Rand() *rand.Rand {
+	stream := gs.NextRandStream()
+	return GameRand(gs.GetSeed(), stream)
+}
+
+func (gs *GameState) SetSeed(seed uint64) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Seed = seed
+	gs.RandDraws = 0
+}
+
+func (gs *GameState) GetSeed() uint64 {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Seed
+}
+
+// playerStream spreads players' streams apart so that two players drawing
+// for the first time don't get the same numbers.
+func playerStream(username string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(username))
+	return h.Sum64()
+}
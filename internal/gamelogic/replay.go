@@ -0,0 +1,179 @@
+package gamelogic
+
+import (
+	"errors"
+	"sort"
+)
+
+// Replay rebuilds a game from its events, one step at a time.
+type Replay struct {
+	events   []Event
+	next     int
+	scenario *Scenario
+	seed     uint64
+	gameID   string
+	paused   bool
+	states   map[string]*GameState
+}
+
+// NewReplay prepares to replay events, which must begin with the game's
+// start event.
+func NewReplay(events []Event) (*Replay, error) {
+	if len(events) == 0 || events[0].Kind != EventStart || events[0].Scenario == nil {
+		return nil, errors.New("the event log does not start at the beginning of a game")
+	}
+	r := &Replay{events: events}
+	r.Reset()
+	return r, nil
+}
+
+// Reset goes back to before the first event.
+func (r *Replay) Reset() {
+	r.next = 0
+	r.scenario = nil
+	r.seed = 0
+	r.gameID = ""
+	r.paused = false
+	r.states = map[string]*GameState{}
+}
+
+// Step applies the next event and returns it, or returns false at the end.
+func (r *Replay) Step() (Event, bool) {
+	if r.next >= len(r.events) {
+		return Event{}, false
+	}
+	e := r.events[r.next]
+	r.next++
+	r.apply(e)
+	return e, true
+}
+
+// Seek replays up to and including the event numbered seq. It never goes
+// back past the start of the game.
+func (r *Replay) Seek(seq int) {
+	if r.next > 0 && r.events[r.next-1].Seq > seq {
+		r.Reset()
+	}
+	if r.next == 0 {
+		r.Step()
+	}
+	for r.next < len(r.events) && r.events[r.next].Seq <= seq {
+		r.Step()
+	}
+}
+
+// Seq is the number of the last event applied, or zero before the first.
+func (r *Replay) Seq() int {
+	if r.next == 0 {
+		return 0
+	}
+	return r.events[r.next-1].Seq
+}
+
+func (r *Replay) Len() int {
+	return len(r.events)
+}
+
+func (r *Replay) Scenario() *Scenario {
+	return r.scenario
+}
+
+// Player returns a player's state as of the last event applied.
+func (r *Replay) Player(username string) (*GameState, bool) {
+	gs, ok := r.states[username]
+	return gs, ok
+}
+
+func (r *Replay) Usernames() []string {
+	usernames := []string{}
+	for username := range r.states {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+func (r *Replay) player(username string) *GameState {
+	gs, ok := r.states[username]
+	if !ok {
+		gs = NewGameState(username)
+		gs.SetGameID(r.gameID)
+		if r.scenario != nil {
+			gs.SetScenario(r.scenario)
+		}
+		gs.SetSeed(r.seed)
+		gs.SetPaused(r.paused)
+		r.states[username] = gs
+	}
+	return gs
+}
+
+func (r *Replay) apply(e Event) {
+	switch e.Kind {
+	case EventStart:
+		r.scenario = e.Scenario
+		r.seed = e.Seed
+		r.gameID = e.GameID
+	case EventJoin:
+		r.player(e.Username).SetTreasury(e.Gold)
+	case EventSpawn:
+		gs := r.player(e.Username)
+		gs.spend(e.Gold)
+		gs.addUnit(*e.Unit)
+		gs.mu.Lock()
+		gs.NextUnitID = max(gs.NextUnitID, e.Unit.ID+1)
+		gs.mu.Unlock()
+	case EventMove:
+		gs := r.player(e.Username)
+		for _, unit := range e.Move.Units {
+			gs.UpdateUnit(unit)
+		}
+	case EventWar:
+		// Wars change nothing until their result comes in.
+	case EventWarResult:
+		wr := *e.WarResult
+		r.player(wr.Attacker).ApplyWarResult(wr)
+		r.player(wr.Defender).ApplyWarResult(wr)
+		for _, ally := range wr.DefenderAllies {
+			r.player(ally).ApplyWarResult(wr)
+		}
+	case EventIncome:
+		gs := r.player(e.Username)
+		gs.SetTreasury(gs.GetTreasury() + e.Gold)
+	case EventPause, EventResume:
+		paused := e.Kind == EventPause
+		if e.Username != "" {
+			r.player(e.Username).SetPaused(paused)
+			return
+		}
+		r.paused = paused
+		for _, gs := range r.states {
+			gs.SetPaused(paused)
+		}
+	case EventAlliance:
+		r.player(e.Username).AddAlly(e.Ally)
+		r.player(e.Ally).AddAlly(e.Username)
+	case EventBreak:
+		r.player(e.Username).RemoveAlly(e.Ally)
+		r.player(e.Ally).RemoveAlly(e.Username)
+	}
+}
+
+// GameEvents picks one game's events out of a log that may hold several.
+// If gameID is empty it picks the last game started in the log.
+func GameEvents(events []Event, gameID string) []Event {
+	if gameID == "" {
+		for _, e := range events {
+			if e.Kind == EventStart {
+				gameID = e.GameID
+			}
+		}
+	}
+	picked := []Event{}
+	for _, e := range events {
+		if e.GameID == gameID {
+			picked = append(picked, e)
+		}
+	}
+	return picked
+}
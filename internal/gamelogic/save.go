@@ -0,0 +1,160 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SaveVersion is the version of the save file format written by WriteSave.
+// Bump it whenever a change would stop older files from loading correctly.
+const SaveVersion = 1
+
+// SaveFile is a game saved to disk. Clients save just their own player;
+// the server saves every player so it can pick the game back up after a
+// restart.
+type SaveFile struct {
+	Version  int
+	SavedAt  time.Time
+	GameID   string
+	Scenario *Scenario
+	Seed     uint64
+	Paused   bool
+	// Turn is the number of the turn in progress, or zero in real time.
+	Turn int
+	// TimeUsed is how much of the time limit had passed, so a restored
+	// game gets only what was left of it.
+	TimeUsed time.Duration
+	// Orders are the intents queued for the end of the turn.
+	Orders  []Intent
+	Over    bool
+	Players []Snapshot
+	// Seats are the only players allowed to join, if set.
+	Seats []string
+}
+
+// Snapshot is everything about one player that needs saving.
+type Snapshot struct {
+	Player     Player
+	Paused     bool
+	NextUnitID int
+	RandDraws  uint64
+}
+
+func (gs *GameState) Snapshot() Snapshot {
+	player := gs.GetPlayerSnap()
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return Snapshot{
+		Player:     player,
+		Paused:     gs.Paused,
+		NextUnitID: gs.NextUnitID,
+		RandDraws:  gs.RandDraws,
+	}
+}
+
+// Restore replaces the player's state with a snapshot. The seed must be set
+// first, as setting it resets the random draws.
+func (gs *GameState) Restore(s Snapshot) {
+	gs.setPlayer(s.Player)
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Paused = s.Paused
+	gs.NextUnitID = s.NextUnitID
+	gs.RandDraws = s.RandDraws
+}
+
+func (gs *GameState) SetGameID(id string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.GameID = id
+}
+
+func (gs *GameState) GetGameID() string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.GameID
+}
+
+// WriteSave writes sf to path, stamped with the current version and time.
+// The file is replaced in one step, so a crash never leaves half a save.
+func WriteSave(path string, sf SaveFile) error {
+	sf.Version = SaveVersion
+	sf.SavedAt = time.Now()
+	data, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode save: %v", err)
+	}
+
+	err = replaceFile(path, data)
+	if err != nil {
+		return fmt.Errorf("could not write save: %v", err)
+	}
+	return nil
+}
+
+// replaceFile writes data to a temporary file next to path and renames it
+// into place.
+func replaceFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadSave reads a save written by WriteSave.
+func ReadSave(path string) (SaveFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SaveFile{}, fmt.Errorf("could not read save: %v", err)
+	}
+	sf, err := ParseSave(data)
+	if err != nil {
+		return SaveFile{}, fmt.Errorf("save %s: %v", path, err)
+	}
+	return sf, nil
+}
+
+// ParseSave decodes and checks a save written by WriteSave, e.g. one a client
+// sent to the server to load.
+func ParseSave(data []byte) (SaveFile, error) {
+	sf := SaveFile{}
+	err := json.Unmarshal(data, &sf)
+	if err != nil {
+		return SaveFile{}, fmt.Errorf("invalid save: %v", err)
+	}
+	if sf.Version != SaveVersion {
+		return SaveFile{}, fmt.Errorf("save is version %v, expected %v", sf.Version, SaveVersion)
+	}
+	if sf.Scenario != nil {
+		err = sf.Scenario.init()
+		if err != nil {
+			return SaveFile{}, fmt.Errorf("invalid scenario in save: %v", err)
+		}
+	}
+	return sf, nil
+}
+
+// Save writes the player's state to path.
+func (gs *GameState) Save(path string) error {
+	return WriteSave(path, SaveFile{
+		GameID:   gs.GetGameID(),
+		Scenario: gs.GetScenario(),
+		Seed:     gs.GetSeed(),
+		Paused:   gs.isPaused(),
+		Players:  []Snapshot{gs.Snapshot()},
+	})
+}
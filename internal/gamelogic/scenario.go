@@ -0,0 +1,323 @@
+package gamelogic
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+const defaultTerrain = "plains"
+
+// Scenario describes everything about a game that isn't player state: the
+// board, the terrain on it, where players may start and the units they can
+// field. The server loads one at startup and sends it to every client.
+type Scenario struct {
+	Name                string             `json:"name"`
+	Locations           []LocationInfo     `json:"locations"`
+	Edges               [][2]Location      `json:"edges"`
+	Travel              bool               `json:"travel"`
+	StartingTerritories []Location         `json:"starting_territories"`
+	Terrains            map[string]Terrain `json:"terrains"`
+	Units               []UnitType         `json:"units"`
+	// StartingTreasury is the gold each player starts with. Zero means
+	// the default.
+	StartingTreasury int `json:"starting_treasury"`
+	// Combat names the CombatResolver used for wars, see the Combat*
+	// constants. Matchups and DefenderBonus only affect the attrition
+	// model.
+	Combat        string                            `json:"combat"`
+	Matchups      map[UnitRank]map[UnitRank]float64 `json:"matchups"`
+	DefenderBonus float64                           `json:"defender_bonus"`
+	Victory       Victory                           `json:"victory"`
+	board         Board
+	combat        CombatResolver
+	units         map[UnitRank]UnitType
+	terrains      map[Location]Terrain
+	incomes       map[Location]int
+	timeLimit     time.Duration
+}
+
+type LocationInfo struct {
+	Name    Location `json:"name"`
+	Terrain string   `json:"terrain"`
+	// Income is the gold the location earns whoever holds it each tick.
+	// Zero means the default.
+	Income int `json:"income"`
+}
+
+// Terrain modifies the power of units fighting in a location.
+type Terrain struct {
+	AttackMultiplier  float64 `json:"attack_multiplier"`
+	DefenseMultiplier float64 `json:"defense_multiplier"`
+}
+
+type UnitType struct {
+	Rank  UnitRank `json:"rank"`
+	Power int      `json:"power"`
+	Cost  int      `json:"cost"`
+}
+
+// DefaultScenario is the original Peril game: six continents, connected
+// roughly the way they are on a globe, and three kinds of unit.
+func DefaultScenario() *Scenario {
+	sc := &Scenario{
+		Name: "continents",
+		Edges: [][2]Location{
+			{"americas", "europe"},
+			{"americas", "africa"},
+			{"americas", "asia"},
+			{"americas", "antarctica"},
+			{"europe", "africa"},
+			{"europe", "asia"},
+			{"africa", "asia"},
+			{"africa", "antarctica"},
+			{"asia", "australia"},
+			{"australia", "antarctica"},
+		},
+		Terrains: map[string]Terrain{
+			defaultTerrain: {AttackMultiplier: 1, DefenseMultiplier: 1},
+		},
+		Units: []UnitType{
+			{Rank: RankInfantry, Power: 1, Cost: 1},
+			{Rank: RankCavalry, Power: 5, Cost: 3},
+			{Rank: RankArtillery, Power: 10, Cost: 5},
+		},
+		Combat: CombatClassic,
+		Matchups: map[UnitRank]map[UnitRank]float64{
+			RankInfantry:  {RankCavalry: 1.25},
+			RankCavalry:   {RankArtillery: 1.5},
+			RankArtillery: {RankInfantry: 1.5},
+		},
+		DefenderBonus: 1.2,
+		Victory: Victory{
+			ControlLocations: 4,
+			Elimination:      true,
+		},
+	}
+	for loc := range getAllLocations() {
+		sc.Locations = append(sc.Locations, LocationInfo{Name: loc, Terrain: defaultTerrain})
+	}
+	slices.SortFunc(sc.Locations, func(a, b LocationInfo) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	err := sc.init()
+	if err != nil {
+		panic(err)
+	}
+	return sc
+}
+
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read scenario file: %v", err)
+	}
+	sc, err := ParseScenario(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %v", path, err)
+	}
+	return sc, nil
+}
+
+func ParseScenario(data []byte) (*Scenario, error) {
+	sc := &Scenario{}
+	err := json.Unmarshal(data, sc)
+	if err != nil {
+		return nil, err
+	}
+	err = sc.init()
+	if err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// init validates the scenario and builds the lookups used during play. It
+// must be called after the exported fields are filled in.
+func (sc *Scenario) init() error {
+	if len(sc.Locations) == 0 {
+		return errors.New("a scenario needs at least one location")
+	}
+	if len(sc.Units) == 0 {
+		return errors.New("a scenario needs at least one unit type")
+	}
+
+	for name, t := range sc.Terrains {
+		if t.AttackMultiplier <= 0 || t.DefenseMultiplier <= 0 {
+			return fmt.Errorf("terrain %s must have positive multipliers", name)
+		}
+	}
+
+	locations := map[Location]struct{}{}
+	sc.terrains = map[Location]Terrain{}
+	sc.incomes = map[Location]int{}
+	for _, info := range sc.Locations {
+		if info.Name == "" {
+			return errors.New("locations must have a name")
+		}
+		if _, ok := locations[info.Name]; ok {
+			return fmt.Errorf("location %s is defined more than once", info.Name)
+		}
+		locations[info.Name] = struct{}{}
+
+		terrain := Terrain{AttackMultiplier: 1, DefenseMultiplier: 1}
+		if info.Terrain != "" {
+			t, ok := sc.Terrains[info.Terrain]
+			if !ok {
+				return fmt.Errorf("location %s has unknown terrain %s", info.Name, info.Terrain)
+			}
+			terrain = t
+		}
+		sc.terrains[info.Name] = terrain
+
+		if info.Income < 0 {
+			return fmt.Errorf("location %s can not have negative income", info.Name)
+		}
+		if info.Income > 0 {
+			sc.incomes[info.Name] = info.Income
+		}
+	}
+
+	board, err := NewBoard(locations, sc.Edges)
+	if err != nil {
+		return err
+	}
+	board.Travel = sc.Travel
+	sc.board = board
+
+	for _, loc := range sc.StartingTerritories {
+		if !board.HasLocation(loc) {
+			return fmt.Errorf("starting territory %s is not a location", loc)
+		}
+	}
+
+	sc.units = map[UnitRank]UnitType{}
+	for _, ut := range sc.Units {
+		if ut.Rank == "" {
+			return errors.New("unit types must have a rank")
+		}
+		if _, ok := sc.units[ut.Rank]; ok {
+			return fmt.Errorf("unit type %s is defined more than once", ut.Rank)
+		}
+		if ut.Power < 0 || ut.Cost < 0 {
+			return fmt.Errorf("unit type %s can not have negative power or cost", ut.Rank)
+		}
+		sc.units[ut.Rank] = ut
+	}
+
+	for rank, against := range sc.Matchups {
+		if _, ok := sc.units[rank]; !ok {
+			return fmt.Errorf("matchup for unknown unit type %s", rank)
+		}
+		for enemy, multiplier := range against {
+			if _, ok := sc.units[enemy]; !ok {
+				return fmt.Errorf("matchup against unknown unit type %s", enemy)
+			}
+			if multiplier <= 0 {
+				return fmt.Errorf("matchup of %s against %s must be positive", rank, enemy)
+			}
+		}
+	}
+	if sc.StartingTreasury < 0 {
+		return errors.New("starting treasury can not be negative")
+	}
+	if sc.StartingTreasury == 0 {
+		sc.StartingTreasury = defaultStartingTreasury
+	}
+	if sc.DefenderBonus < 0 {
+		return errors.New("defender bonus can not be negative")
+	}
+
+	if sc.Victory.ControlLocations < 0 || sc.Victory.ControlLocations > len(sc.Locations) {
+		return fmt.Errorf("victory can not require controlling %v locations", sc.Victory.ControlLocations)
+	}
+	sc.timeLimit = 0
+	if sc.Victory.TimeLimit != "" {
+		limit, err := time.ParseDuration(sc.Victory.TimeLimit)
+		if err != nil || limit <= 0 {
+			return fmt.Errorf("invalid time limit %q", sc.Victory.TimeLimit)
+		}
+		sc.timeLimit = limit
+	}
+
+	return sc.SetCombat(sc.Combat)
+}
+
+// SetCombat switches the scenario to one of the Combat* models.
+func (sc *Scenario) SetCombat(model string) error {
+	combat, err := NewCombatResolver(model)
+	if err != nil {
+		return err
+	}
+	if model == "" {
+		model = CombatClassic
+	}
+	sc.Combat = model
+	sc.combat = combat
+	return nil
+}
+
+func (sc *Scenario) CombatResolver() CombatResolver {
+	return sc.combat
+}
+
+// EnableTravel lets units be ordered to locations they don't border.
+func (sc *Scenario) EnableTravel() {
+	sc.Travel = true
+	sc.board.Travel = true
+}
+
+func (sc *Scenario) Board() Board {
+	return sc.board
+}
+
+func (sc *Scenario) UnitType(rank UnitRank) (UnitType, bool) {
+	ut, ok := sc.units[rank]
+	return ut, ok
+}
+
+func (sc *Scenario) Terrain(loc Location) Terrain {
+	t, ok := sc.terrains[loc]
+	if !ok {
+		return Terrain{AttackMultiplier: 1, DefenseMultiplier: 1}
+	}
+	return t
+}
+
+// CanStartIn reports whether a player may spawn units in loc without
+// already holding it.
+func (sc *Scenario) CanStartIn(loc Location) bool {
+	if len(sc.StartingTerritories) == 0 {
+		return true
+	}
+	return slices.Contains(sc.StartingTerritories, loc)
+}
+
+// matchup is how much stronger a unit of one rank is against another.
+func (sc *Scenario) matchup(rank UnitRank, enemy UnitRank) float64 {
+	multiplier, ok := sc.Matchups[rank][enemy]
+	if !ok {
+		return 1
+	}
+	return multiplier
+}
+
+func (sc *Scenario) defenderBonus() float64 {
+	if sc.DefenderBonus == 0 {
+		return 1
+	}
+	return sc.DefenderBonus
+}
+
+func (sc *Scenario) power(units []Unit) int {
+	power := 0
+	for _, unit := range units {
+		power += sc.units[unit.Rank].Power
+	}
+	return power
+}
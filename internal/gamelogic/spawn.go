@@ -5,30 +5,60 @@ import (
 	"fmt"
 )
 
-func (gs *GameState) CommandSpawn(words []string) error {
+// CommandSpawn checks a spawn command against the local state and returns
+// the intent to send to the server.
+func (gs *GameState) CommandSpawn(words []string) (Intent, error) {
 	if len(words) < 3 {
-		return errors.New("usage: spawn <location> <rank>")
+		return Intent{}, errors.New("usage: spawn <location> <rank>")
 	}
 
-	locationName := words[1]
-	locations := getAllLocations()
-	if _, ok := locations[Location(locationName)]; !ok {
-		return fmt.Errorf("error: %s is not a valid location", locationName)
+	intent := Intent{
+		GameID:   gs.GetGameID(),
+		Username: gs.GetUsername(),
+		Kind:     IntentSpawn,
+		Location: Location(words[1]),
+		Rank:     UnitRank(words[2]),
 	}
+	err := gs.validateSpawn(intent)
+	if err != nil {
+		return Intent{}, err
+	}
+	return intent, nil
+}
 
-	rank := words[2]
-	units := getAllRanks()
-	if _, ok := units[UnitRank(rank)]; !ok {
-		return fmt.Errorf("error: %s is not a valid unit", rank)
+// ApplySpawn validates a spawn intent, pays for the new unit and adds it.
+func (gs *GameState) ApplySpawn(intent Intent) (Unit, error) {
+	err := gs.validateSpawn(intent)
+	if err != nil {
+		return Unit{}, err
+	}
+	ut, _ := gs.GetScenario().UnitType(intent.Rank)
+	if !gs.spend(ut.Cost) {
+		return Unit{}, gs.canAfford(intent.Rank)
 	}
 
-	id := len(gs.getUnitsSnap()) + 1
-	gs.addUnit(Unit{
+	id := gs.allocateUnitID()
+	unit := Unit{
 		ID:       id,
-		Rank:     UnitRank(rank),
-		Location: Location(locationName),
-	})
+		Rank:     intent.Rank,
+		Location: intent.Location,
+	}
+	gs.addUnit(unit)
+	return unit, nil
+}
 
-	fmt.Printf("Spawned a(n) %s in %s with id %v\n", rank, locationName, id)
-	return nil
+func (gs *GameState) validateSpawn(intent Intent) error {
+	sc := gs.GetScenario()
+	if !sc.Board().HasLocation(intent.Location) {
+		return fmt.Errorf("error: %s is not a valid location", intent.Location)
+	}
+
+	if _, ok := sc.UnitType(intent.Rank); !ok {
+		return fmt.Errorf("error: %s is not a valid unit", intent.Rank)
+	}
+
+	if !sc.CanStartIn(intent.Location) && !gs.hasUnitsIn(intent.Location) {
+		return fmt.Errorf("error: you can only spawn units in a starting territory or where you already have units")
+	}
+	return gs.canAfford(intent.Rank)
 }
@@ -0,0 +1,190 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/unappendixed/bootdevpubsub/internal/routing"
+)
+
+// Spectator follows a game without playing in it. It only knows what it has
+// seen: units are placed where they were last spotted moving or fighting, and
+// forgotten once they are killed.
+type Spectator struct {
+	Username string
+	scenario *Scenario
+	paused   bool
+	// units holds every unit seen so far, keyed by player and unit ID.
+	units map[string]map[int]Unit
+	mu    *sync.RWMutex
+}
+
+func NewSpectator(username string) *Spectator {
+	return &Spectator{
+		Username: username,
+		scenario: DefaultScenario(),
+		units:    map[string]map[int]Unit{},
+		mu:       &sync.RWMutex{},
+	}
+}
+
+func (s *Spectator) HandleGameInfo(gi GameInfo) error {
+	if gi.Scenario == nil {
+		return errors.New("game info is missing a scenario")
+	}
+	err := gi.Scenario.init()
+	if err != nil {
+		return fmt.Errorf("invalid scenario: %v", err)
+	}
+	s.mu.Lock()
+	s.scenario = gi.Scenario
+	s.mu.Unlock()
+
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Printf("==== Watching %s ====\n", gi.Scenario.Name)
+	fmt.Printf("Game %s, seed %v\n", gi.GameID, gi.Seed)
+	if gi.Turn != nil {
+		fmt.Printf("The game is played in turns. Turn %v ends at %s.\n", gi.Turn.Number, gi.Turn.Deadline.Format(time.TimeOnly))
+	}
+	fmt.Println("Use the status command to see every player's known positions.")
+	return nil
+}
+
+// spot records where a player's units were seen.
+func (s *Spectator) spot(p Player) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	units, ok := s.units[p.Username]
+	if !ok {
+		units = map[int]Unit{}
+		s.units[p.Username] = units
+	}
+	for id, unit := range p.Units {
+		units[id] = unit
+	}
+}
+
+func (s *Spectator) kill(username string, casualties []int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range casualties {
+		delete(s.units[username], id)
+	}
+}
+
+func (s *Spectator) HandleMove(move ArmyMove) {
+	s.spot(move.Player)
+	fmt.Println()
+	fmt.Printf("%s moved %v unit(s) to %s\n", move.Player.Username, len(move.Units), move.ToLocation)
+	s.PrintSummary()
+}
+
+func (s *Spectator) HandleWar(rw RecognitionOfWar) {
+	s.spot(rw.Attacker)
+	s.spot(rw.Defender)
+	for _, ally := range rw.DefenderAllies {
+		s.spot(ally)
+	}
+}
+
+func (s *Spectator) HandleWarResult(wr WarResult) {
+	s.kill(wr.Attacker, wr.AttackerCasualties)
+	s.kill(wr.Defender, wr.DefenderCasualties)
+	for ally, casualties := range wr.AllyCasualties {
+		s.kill(ally, casualties)
+	}
+
+	fmt.Println()
+	if wr.Draw {
+		fmt.Printf("The war between %s and %s in %s ended in a draw.\n", wr.Attacker, wr.Defender, wr.Location)
+	} else {
+		fmt.Printf("%s defeated %s in %s.\n", wr.Winner, wr.Loser, wr.Location)
+	}
+	fmt.Printf("Casualties: %s lost %v unit(s), %s lost %v unit(s)\n", wr.Attacker, len(wr.AttackerCasualties), wr.Defender, len(wr.DefenderCasualties))
+	for _, ally := range wr.DefenderAllies {
+		fmt.Printf("Casualties: %s lost %v unit(s)\n", ally, len(wr.AllyCasualties[ally]))
+	}
+	s.PrintSummary()
+}
+
+func (s *Spectator) HandlePause(ps routing.PlayingState) {
+	s.mu.Lock()
+	s.paused = ps.IsPaused
+	s.mu.Unlock()
+	fmt.Println()
+	if ps.IsPaused {
+		fmt.Println("The game has been paused.")
+	} else {
+		fmt.Println("The game has been resumed.")
+	}
+}
+
+func (s *Spectator) HandleTurn(turn Turn) {
+	fmt.Println()
+	fmt.Printf("==== Turn %v ====\n", turn.Number)
+	fmt.Printf("Orders are due by %s.\n", turn.Deadline.Format(time.TimeOnly))
+}
+
+func (s *Spectator) HandleGameOver(over GameOver) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Game Over ====")
+	if over.Winner == "" {
+		fmt.Printf("The game ended in a draw: %s.\n", over.Reason)
+	} else {
+		fmt.Printf("%s won: %s.\n", over.Winner, over.Reason)
+	}
+	PrintStandings(over.Standings)
+}
+
+// PrintSummary shows every player's known units, grouped by location.
+func (s *Spectator) PrintSummary() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	defer fmt.Println("------------------------")
+	if s.paused {
+		fmt.Println("The game is paused.")
+	}
+	if len(s.units) == 0 {
+		fmt.Println("No units have been seen yet.")
+		return
+	}
+
+	usernames := []string{}
+	for username := range s.units {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	for _, username := range usernames {
+		byLocation := map[Location][]UnitRank{}
+		for _, unit := range s.units[username] {
+			byLocation[unit.Location] = append(byLocation[unit.Location], unit.Rank)
+		}
+		if len(byLocation) == 0 {
+			fmt.Printf("%s: no known units\n", username)
+			continue
+		}
+		fmt.Printf("%s:\n", username)
+		locations := []Location{}
+		for loc := range byLocation {
+			locations = append(locations, loc)
+		}
+		sort.Slice(locations, func(i, j int) bool {
+			return locations[i] < locations[j]
+		})
+		for _, loc := range locations {
+			fmt.Printf("* %s: %v\n", loc, byLocation[loc])
+		}
+	}
+}
+
+func (s *Spectator) CommandMap() {
+	s.mu.RLock()
+	sc := s.scenario
+	s.mu.RUnlock()
+	printMap(sc)
+}
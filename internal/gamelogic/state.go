@@ -0,0 +1,60 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (gs *GameState) HandleStateUpdate(su StateUpdate) {
+	if su.Player.Username != gs.GetUsername() {
+		return
+	}
+	if su.Error != "" {
+		fmt.Println()
+		fmt.Printf("The server rejected your command: %s\n", su.Error)
+	} else if su.Message != "" {
+		fmt.Println()
+		fmt.Println(su.Message)
+	}
+	gs.setPlayer(su.Player)
+}
+
+// SetPlayer replaces the player's units, treasury and allies with those in p,
+// without printing anything.
+func (gs *GameState) SetPlayer(p Player) {
+	gs.setPlayer(p)
+}
+
+// ApplyGameInfo sets up the game described by gi without printing anything.
+func (gs *GameState) ApplyGameInfo(gi GameInfo) error {
+	if gi.Scenario == nil {
+		return errors.New("game info is missing a scenario")
+	}
+	err := gi.Scenario.init()
+	if err != nil {
+		return fmt.Errorf("invalid scenario: %v", err)
+	}
+	gs.SetGameID(gi.GameID)
+	gs.SetScenario(gi.Scenario)
+	gs.SetSeed(gi.Seed)
+	gs.SetTurn(gi.Turn)
+	return nil
+}
+
+func (gs *GameState) HandleGameInfo(gi GameInfo) error {
+	err := gs.ApplyGameInfo(gi)
+	if err != nil {
+		return err
+	}
+
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Printf("==== Playing %s ====\n", gi.Scenario.Name)
+	fmt.Printf("Game %s, seed %v\n", gi.GameID, gi.Seed)
+	if gi.Turn != nil {
+		fmt.Printf("The game is played in turns. Send your orders for turn %v by %s.\n", gi.Turn.Number, gi.Turn.Deadline.Format(time.TimeOnly))
+	}
+	fmt.Println("Use the map command to see the board.")
+	return nil
+}
@@ -0,0 +1,207 @@
+package gamelogic
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+)
+
+// PlayerStats is a player's record across every game they have played.
+type PlayerStats struct {
+	Username string
+	// Games counts the games the player finished, and Wins those they won.
+	Games        int
+	Wins         int
+	WarsWon      int
+	WarsLost     int
+	WarsDrawn    int
+	UnitsSpawned int
+	UnitsLost    int
+	// Territories adds up the locations the player held at the end of each
+	// of their games.
+	Territories int
+}
+
+func compareStats(a, b PlayerStats) int {
+	return cmp.Or(
+		cmp.Compare(b.Wins, a.Wins),
+		cmp.Compare(b.WarsWon, a.WarsWon),
+		cmp.Compare(b.Territories, a.Territories),
+		cmp.Compare(a.Username, b.Username),
+	)
+}
+
+// Stats aggregates every player's statistics from the events of every game
+// and keeps them in a file, if it has one, so they outlive the games. Changes
+// are only written to the file by Flush.
+type Stats struct {
+	players map[string]*PlayerStats
+	path    string
+	// dirty is set while there are changes Flush hasn't written yet.
+	dirty bool
+	// saveMu keeps writes in order. Take it before mu, never after.
+	saveMu *sync.Mutex
+	mu     *sync.Mutex
+}
+
+// LoadStats reads the statistics kept at path, starting afresh if the file
+// doesn't exist yet. An empty path keeps them in memory only.
+func LoadStats(path string) (*Stats, error) {
+	s := &Stats{
+		players: map[string]*PlayerStats{},
+		path:    path,
+		saveMu:  &sync.Mutex{},
+		mu:      &sync.Mutex{},
+	}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read stats: %v", err)
+	}
+	players := []PlayerStats{}
+	err = json.Unmarshal(data, &players)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stats %s: %v", path, err)
+	}
+	for _, ps := range players {
+		s.players[ps.Username] = &ps
+	}
+	return s, nil
+}
+
+// player returns username's statistics, creating them on first use. The
+// caller must hold s.mu.
+func (s *Stats) player(username string) *PlayerStats {
+	ps, ok := s.players[username]
+	if !ok {
+		ps = &PlayerStats{Username: username}
+		s.players[username] = ps
+	}
+	return ps
+}
+
+// Record counts an event towards the statistics of the players in it. It
+// never touches the file, so it is cheap enough to call under other locks.
+func (s *Stats) Record(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch e.Kind {
+	case EventSpawn:
+		s.player(e.Username).UnitsSpawned++
+	case EventWarResult:
+		wr := e.WarResult
+		s.recordWar(wr.Attacker, wr, wr.AttackerCasualties)
+		s.recordWar(wr.Defender, wr, wr.DefenderCasualties)
+		for _, ally := range wr.DefenderAllies {
+			s.recordWar(ally, wr, wr.AllyCasualties[ally])
+		}
+	case EventGameOver:
+		for _, standing := range e.GameOver.Standings {
+			ps := s.player(standing.Username)
+			ps.Games++
+			ps.Territories += standing.Locations
+			if standing.Username == e.GameOver.Winner {
+				ps.Wins++
+			}
+		}
+	default:
+		return
+	}
+	s.dirty = true
+}
+
+// recordWar counts a war for one of the players in it. Allies win or lose
+// along with the defender. The caller must hold s.mu.
+func (s *Stats) recordWar(username string, wr *WarResult, casualties []int) {
+	ps := s.player(username)
+	ps.UnitsLost += len(casualties)
+	switch {
+	case wr.Draw:
+		ps.WarsDrawn++
+	case wr.Winner == username || (wr.Winner == wr.Defender && username != wr.Attacker):
+		ps.WarsWon++
+	default:
+		ps.WarsLost++
+	}
+}
+
+// Flush writes any changes to the statistics to their file, if they have
+// one.
+func (s *Stats) Flush() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.Lock()
+	if !s.dirty || s.path == "" {
+		s.mu.Unlock()
+		return nil
+	}
+	s.dirty = false
+	board := s.leaderboard()
+	s.mu.Unlock()
+
+	data, err := json.MarshalIndent(board, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode stats: %v", err)
+	}
+	err = replaceFile(s.path, data)
+	if err != nil {
+		// Try again on the next flush.
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		return fmt.Errorf("could not write stats: %v", err)
+	}
+	return nil
+}
+
+// Get returns username's statistics, or false if they have none yet.
+func (s *Stats) Get(username string) (PlayerStats, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps, ok := s.players[username]
+	if !ok {
+		return PlayerStats{}, false
+	}
+	return *ps, true
+}
+
+// Leaderboard returns every player's statistics, ranked by games won, then
+// wars won, then territories held.
+func (s *Stats) Leaderboard() []PlayerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leaderboard()
+}
+
+// leaderboard is Leaderboard for callers that already hold s.mu.
+func (s *Stats) leaderboard() []PlayerStats {
+	board := []PlayerStats{}
+	for _, ps := range s.players {
+		board = append(board, *ps)
+	}
+	slices.SortFunc(board, compareStats)
+	return board
+}
+
+func PrintLeaderboard(board []PlayerStats) {
+	if len(board) == 0 {
+		fmt.Println("No games have been played yet.")
+		return
+	}
+	fmt.Println("Leaderboard:")
+	for i, ps := range board {
+		fmt.Printf(
+			"%v. %s: %v win(s) in %v game(s), wars %v-%v-%v, %v unit(s) spawned, %v lost, %v territories\n",
+			i+1, ps.Username, ps.Wins, ps.Games, ps.WarsWon, ps.WarsLost, ps.WarsDrawn, ps.UnitsSpawned, ps.UnitsLost, ps.Territories,
+		)
+	}
+}
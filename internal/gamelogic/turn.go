@@ -0,0 +1,41 @@
+package gamelogic
+
+import (
+	"fmt"
+	"time"
+)
+
+// Turn announces the start of a turn in a game played in turns. Orders sent
+// before the deadline are all resolved together when the turn ends.
+type Turn struct {
+	Number   int
+	Deadline time.Time
+}
+
+func (gs *GameState) HandleTurn(turn Turn) {
+	gs.SetTurn(&turn)
+
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Printf("==== Turn %v ====\n", turn.Number)
+	fmt.Printf("Send your orders by %s.\n", turn.Deadline.Format(time.TimeOnly))
+}
+
+// SetTurn sets the current turn without printing anything. A nil turn means
+// the game isn't played in turns.
+func (gs *GameState) SetTurn(turn *Turn) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.turn = turn
+}
+
+// GetTurn returns the current turn, or false if the game isn't played in
+// turns.
+func (gs *GameState) GetTurn() (Turn, bool) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	if gs.turn == nil {
+		return Turn{}, false
+	}
+	return *gs.turn, true
+}
@@ -0,0 +1,199 @@
+package gamelogic
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Victory lists the ways a game can be won. The game ends as soon as any
+// condition that is set is met.
+type Victory struct {
+	// ControlLocations wins the game for the first player to control this
+	// many locations. Zero turns the condition off.
+	ControlLocations int `json:"control_locations"`
+	// Elimination wins the game for the last player left standing.
+	Elimination bool `json:"elimination"`
+	// TimeLimit ends the game after this long, e.g. "30m", and the player
+	// with the highest score wins.
+	TimeLimit string `json:"time_limit"`
+}
+
+// GameOver announces the end of a game. Winner is empty if it ended in a
+// draw.
+type GameOver struct {
+	Winner    string
+	Reason    string
+	Standings []Standing
+}
+
+// Standing is a player's score. Players are ranked by the locations they
+// control, then the power of their units, then their treasury.
+type Standing struct {
+	Username  string
+	Locations int
+	Power     int
+	Treasury  int
+}
+
+func compareStandings(a, b Standing) int {
+	return cmp.Or(
+		cmp.Compare(b.Locations, a.Locations),
+		cmp.Compare(b.Power, a.Power),
+		cmp.Compare(b.Treasury, a.Treasury),
+	)
+}
+
+// Control returns who controls each location. A player controls a location
+// while theirs are the only units in it.
+func Control(players []Player) map[Location]string {
+	occupants := map[Location][]string{}
+	for _, p := range players {
+		for _, loc := range territories(p) {
+			occupants[loc] = append(occupants[loc], p.Username)
+		}
+	}
+	control := map[Location]string{}
+	for loc, usernames := range occupants {
+		if len(usernames) == 1 {
+			control[loc] = usernames[0]
+		}
+	}
+	return control
+}
+
+// Standings ranks the players, best first.
+func Standings(sc *Scenario, players []Player) []Standing {
+	control := Control(players)
+	standings := []Standing{}
+	for _, p := range players {
+		units := []Unit{}
+		for _, unit := range p.Units {
+			units = append(units, unit)
+		}
+		s := Standing{
+			Username: p.Username,
+			Power:    sc.power(units),
+			Treasury: p.Treasury,
+		}
+		for _, owner := range control {
+			if owner == p.Username {
+				s.Locations++
+			}
+		}
+		standings = append(standings, s)
+	}
+	slices.SortFunc(standings, func(a, b Standing) int {
+		return cmp.Or(compareStandings(a, b), cmp.Compare(a.Username, b.Username))
+	})
+	return standings
+}
+
+// CheckVictory reports whether any player has met one of the scenario's
+// victory conditions.
+func CheckVictory(sc *Scenario, players []Player) (GameOver, bool) {
+	standings := Standings(sc, players)
+	if len(standings) == 0 {
+		return GameOver{}, false
+	}
+
+	if sc.Victory.ControlLocations > 0 && standings[0].Locations >= sc.Victory.ControlLocations {
+		return GameOver{
+			Winner:    standings[0].Username,
+			Reason:    fmt.Sprintf("%s controls %v locations", standings[0].Username, standings[0].Locations),
+			Standings: standings,
+		}, true
+	}
+
+	// A game needs at least two players before anyone can be the last one
+	// standing.
+	if sc.Victory.Elimination && len(players) > 1 {
+		standing := []string{}
+		for _, p := range players {
+			if !sc.eliminated(p) {
+				standing = append(standing, p.Username)
+			}
+		}
+		switch len(standing) {
+		case 0:
+			return GameOver{Reason: "every player was eliminated", Standings: standings}, true
+		case 1:
+			return GameOver{
+				Winner:    standing[0],
+				Reason:    fmt.Sprintf("%s is the last player standing", standing[0]),
+				Standings: standings,
+			}, true
+		}
+	}
+	return GameOver{}, false
+}
+
+// TimeUp ends the game in favour of the player with the highest score, or
+// in a draw if the best players are tied.
+func TimeUp(sc *Scenario, players []Player) GameOver {
+	standings := Standings(sc, players)
+	over := GameOver{Reason: "time ran out", Standings: standings}
+	if len(standings) == 0 {
+		return over
+	}
+	if len(standings) > 1 && compareStandings(standings[0], standings[1]) == 0 {
+		over.Reason = "time ran out with the best players tied"
+		return over
+	}
+	over.Winner = standings[0].Username
+	over.Reason = fmt.Sprintf("%s had the highest score when time ran out", over.Winner)
+	return over
+}
+
+// eliminated reports whether a player has no units left and can't afford to
+// raise any.
+func (sc *Scenario) eliminated(p Player) bool {
+	if len(p.Units) > 0 {
+		return false
+	}
+	for _, ut := range sc.Units {
+		if ut.Cost <= p.Treasury {
+			return false
+		}
+	}
+	return true
+}
+
+// TimeLimit is how long a game lasts, or zero if it has no time limit.
+func (sc *Scenario) TimeLimit() time.Duration {
+	return sc.timeLimit
+}
+
+func (sc *Scenario) SetTimeLimit(limit time.Duration) {
+	sc.timeLimit = limit
+	sc.Victory.TimeLimit = ""
+	if limit > 0 {
+		sc.Victory.TimeLimit = limit.String()
+	}
+}
+
+func (gs *GameState) HandleGameOver(over GameOver) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Game Over ====")
+	switch over.Winner {
+	case "":
+		fmt.Printf("The game ended in a draw: %s.\n", over.Reason)
+	case gs.GetUsername():
+		fmt.Printf("You won! %s.\n", over.Reason)
+	default:
+		fmt.Printf("%s won: %s.\n", over.Winner, over.Reason)
+	}
+	PrintStandings(over.Standings)
+}
+
+func PrintStandings(standings []Standing) {
+	fmt.Println("Standings:")
+	for i, s := range standings {
+		fmt.Printf(
+			"%v. %s: %v location(s), %v power, %v gold\n",
+			i+1, s.Username, s.Locations, s.Power, s.Treasury,
+		)
+	}
+}
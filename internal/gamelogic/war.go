@@ -2,104 +2,174 @@ package gamelogic
 
 import (
 	"fmt"
+	"slices"
 )
 
-type WarOutcome int
-
-const (
-	WarOutcomeNotInvolved WarOutcome = iota
-	WarOutcomeNoUnits
-	WarOutcomeYouWon
-	WarOutcomeOpponentWon
-	WarOutcomeDraw
-)
+// WarResult is the outcome of a war, shared with both combatants once it has
+// been resolved.
+type WarResult struct {
+	Attacker      string
+	Defender      string
+	Location      Location
+	AttackerPower int
+	DefenderPower int
+	Winner        string
+	Loser         string
+	Draw          bool
+	// The IDs of the units each side lost.
+	AttackerCasualties []int
+	DefenderCasualties []int
+	// DefenderAllies are the allies who defended alongside the defender,
+	// and AllyCasualties the IDs of the units each of them lost.
+	DefenderAllies []string
+	AllyCasualties map[string][]int
+	// RandStream is the stream of the game's seed the war was fought with,
+	// so anyone can check the result with GameRand.
+	RandStream uint64
+}
 
-func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner string, loser string) {
-	defer fmt.Println("------------------------")
-	fmt.Println()
-	fmt.Println("==== War Declared ====")
-	fmt.Printf("%s has declared war on %s!\n", rw.Attacker.Username, rw.Defender.Username)
+// ResolveWar fights a war with the scenario's combat model, using only the
+// snapshots carried in rw and the given stream of the game's seed, so anyone
+// resolving it reaches the same result. Allies of the defender with units in
+// the same location join the defense, summing their power. It returns false
+// if the attacker and defender have no units in the same location.
+func ResolveWar(rw RecognitionOfWar, sc *Scenario, seed uint64, stream uint64) (WarResult, bool) {
+	overlappingLocation := getOverlappingLocation(rw.Attacker, rw.Defender)
+	if overlappingLocation == "" {
+		return WarResult{}, false
+	}
 
-	player := gs.GetPlayerSnap()
+	result := WarResult{
+		Attacker:   rw.Attacker.Username,
+		Defender:   rw.Defender.Username,
+		Location:   overlappingLocation,
+		RandStream: stream,
+	}
 
-	if player.Username == rw.Defender.Username {
-		fmt.Printf("%s, you published the war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+	// Unit IDs are only unique per player, so the defending units are
+	// numbered afresh for the battle and mapped back to their owners after.
+	type owned struct {
+		username string
+		id       int
+	}
+	defenders := []Unit{}
+	owners := map[int]owned{}
+	for _, p := range append([]Player{rw.Defender}, rw.DefenderAllies...) {
+		units := sortedByID(unitsInLocation(p, overlappingLocation))
+		if len(units) == 0 {
+			continue
+		}
+		if p.Username != rw.Defender.Username {
+			result.DefenderAllies = append(result.DefenderAllies, p.Username)
+		}
+		for _, unit := range units {
+			battleID := len(defenders) + 1
+			owners[battleID] = owned{username: p.Username, id: unit.ID}
+			unit.ID = battleID
+			defenders = append(defenders, unit)
+		}
 	}
 
-	if player.Username != rw.Attacker.Username {
-		fmt.Printf("%s, you are not involved in this war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+	outcome := sc.CombatResolver().Resolve(Battle{
+		Location:  overlappingLocation,
+		Attackers: unitsInLocation(rw.Attacker, overlappingLocation),
+		Defenders: defenders,
+		Scenario:  sc,
+		Rand:      GameRand(seed, stream),
+	})
+
+	result.AttackerPower = outcome.AttackerPower
+	result.DefenderPower = outcome.DefenderPower
+	result.AttackerCasualties = outcome.AttackerCasualties
+	result.DefenderCasualties = []int{}
+	for _, battleID := range outcome.DefenderCasualties {
+		unit := owners[battleID]
+		if unit.username == result.Defender {
+			result.DefenderCasualties = append(result.DefenderCasualties, unit.id)
+			continue
+		}
+		if result.AllyCasualties == nil {
+			result.AllyCasualties = map[string][]int{}
+		}
+		result.AllyCasualties[unit.username] = append(result.AllyCasualties[unit.username], unit.id)
 	}
 
-	overlappingLocation := getOverlappingLocation(rw.Attacker, rw.Defender)
-	if overlappingLocation == "" {
-		fmt.Printf("Error! No units are in the same location. No war will be fought.\n")
-		return WarOutcomeNoUnits, "", ""
+	switch {
+	case result.AttackerPower > result.DefenderPower:
+		result.Winner, result.Loser = result.Attacker, result.Defender
+	case result.DefenderPower > result.AttackerPower:
+		result.Winner, result.Loser = result.Defender, result.Attacker
+	default:
+		result.Winner, result.Loser = result.Attacker, result.Defender
+		result.Draw = true
 	}
+	return result, true
+}
 
-	attackerUnits := []Unit{}
-	defenderUnits := []Unit{}
-	for _, unit := range rw.Attacker.Units {
-		if unit.Location == overlappingLocation {
-			attackerUnits = append(attackerUnits, unit)
-		}
+// ApplyWarResult removes the player's units that were killed in the war. It
+// reports whether any units were removed.
+func (gs *GameState) ApplyWarResult(wr WarResult) bool {
+	var casualties []int
+	switch gs.GetUsername() {
+	case wr.Attacker:
+		casualties = wr.AttackerCasualties
+	case wr.Defender:
+		casualties = wr.DefenderCasualties
+	default:
+		casualties = wr.AllyCasualties[gs.GetUsername()]
 	}
-	for _, unit := range rw.Defender.Units {
-		if unit.Location == overlappingLocation {
-			defenderUnits = append(defenderUnits, unit)
+
+	removed := false
+	for _, id := range casualties {
+		if gs.removeUnit(id) {
+			removed = true
 		}
 	}
+	return removed
+}
 
-	fmt.Printf("%s's units:\n", rw.Attacker.Username)
-	for _, unit := range attackerUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
+func (gs *GameState) HandleWarResult(wr WarResult) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== War Result ====")
+	if wr.Draw {
+		fmt.Printf("The war between %s and %s in %s ended in a draw.\n", wr.Attacker, wr.Defender, wr.Location)
+	} else {
+		fmt.Printf("%s defeated %s in %s.\n", wr.Winner, wr.Loser, wr.Location)
 	}
-	fmt.Printf("%s's units:\n", rw.Defender.Username)
-	for _, unit := range defenderUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
+	if len(wr.DefenderAllies) > 0 {
+		fmt.Printf("%s was defended by their allies %v.\n", wr.Defender, wr.DefenderAllies)
 	}
-	attackerPower := unitsToPowerLevel(attackerUnits)
-	defenderPower := unitsToPowerLevel(defenderUnits)
-	fmt.Printf("Attacker has a power level of %v\n", attackerPower)
-	fmt.Printf("Defender has a power level of %v\n", defenderPower)
-	if attackerPower > defenderPower {
-		fmt.Printf("%s has won the war!\n", rw.Attacker.Username)
-		if player.Username == rw.Defender.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Attacker.Username, rw.Defender.Username
-		}
-		return WarOutcomeYouWon, rw.Attacker.Username, rw.Defender.Username
-	} else if defenderPower > attackerPower {
-		fmt.Printf("%s has won the war!\n", rw.Defender.Username)
-		if player.Username == rw.Attacker.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Defender.Username, rw.Attacker.Username
-		}
-		return WarOutcomeYouWon, rw.Defender.Username, rw.Attacker.Username
+	fmt.Printf("Power levels: %s %v, %s %v\n", wr.Attacker, wr.AttackerPower, wr.Defender, wr.DefenderPower)
+	fmt.Printf("Casualties: %s lost %v unit(s), %s lost %v unit(s)\n", wr.Attacker, len(wr.AttackerCasualties), wr.Defender, len(wr.DefenderCasualties))
+	for _, ally := range wr.DefenderAllies {
+		fmt.Printf("Casualties: %s lost %v unit(s)\n", ally, len(wr.AllyCasualties[ally]))
+	}
+	var casualties []int
+	switch username := gs.GetUsername(); username {
+	case wr.Attacker:
+		casualties = wr.AttackerCasualties
+	case wr.Defender:
+		casualties = wr.DefenderCasualties
+	default:
+		casualties = wr.AllyCasualties[username]
+	}
+	if len(casualties) > 0 {
+		fmt.Printf("Your units %v in %s have been killed.\n", casualties, wr.Location)
 	}
-	fmt.Println("The war ended in a draw!")
-	fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-	gs.removeUnitsInLocation(overlappingLocation)
-	return WarOutcomeDraw, rw.Attacker.Username, rw.Defender.Username
 }
 
-func unitsToPowerLevel(units []Unit) int {
-	power := 0
-	for _, unit := range units {
-		if unit.Rank == RankArtillery {
-			power += 10
-		}
-		if unit.Rank == RankCavalry {
-			power += 5
-		}
-		if unit.Rank == RankInfantry {
-			power += 1
+// Involves reports whether username fought in the war.
+func (wr WarResult) Involves(username string) bool {
+	return username == wr.Attacker || username == wr.Defender || slices.Contains(wr.DefenderAllies, username)
+}
+
+func unitsInLocation(p Player, loc Location) []Unit {
+	units := []Unit{}
+	for _, unit := range p.Units {
+		if unit.Location == loc {
+			units = append(units, unit)
 		}
 	}
-	return power
+	return units
 }
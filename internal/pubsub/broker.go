@@ -0,0 +1,270 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Broker is a protocol-independent connection to the message broker. Every
+// command connects with Dial, so any of them can speak AMQP or STOMP. The
+// PublishBroker*, SubscribeBroker*, Call and Serve helpers work with any
+// implementation.
+type Broker interface {
+	Publish(exchange string, key string, contentType string, body []byte) error
+	Subscribe(
+		exchange string,
+		key string,
+		queueName string,
+		simpleQueueType QueueType,
+		handler func(Delivery) AckType,
+	) error
+	// Call publishes a request and waits for the reply from whoever Serves
+	// key. A reply carrying the handler's error is returned as a
+	// RemoteError.
+	Call(ctx context.Context, exchange string, key string, body []byte) ([]byte, error)
+	// Serve answers every Call made to exchange with key. The handler
+	// returns the reply body, or an error to pass back to the caller.
+	Serve(
+		exchange string,
+		key string,
+		queueName string,
+		simpleQueueType QueueType,
+		handler func(Delivery) ([]byte, error),
+	) error
+	IsClosed() bool
+	Close() error
+}
+
+// Delivery is a message received from the broker.
+type Delivery struct {
+	Body []byte
+	// RoutingKey is the key the message was published with, which the
+	// broker can tie to the sender's login.
+	RoutingKey string
+}
+
+// QueueInspector is implemented by brokers that can report on a queue
+// without consuming from it.
+type QueueInspector interface {
+	InspectQueue(name string) (QueueInfo, error)
+}
+
+type QueueInfo struct {
+	Name      string
+	Messages  int
+	Consumers int
+}
+
+var UnsupportedSchemeErr error = errors.New("Unsupported connection string scheme")
+
+// Dial connects to the broker at connstr, picking the protocol from the
+// scheme: amqp:// and amqps:// speak AMQP 0-9-1, stomp:// and stomp+ssl://
+// speak STOMP.
+func Dial(connstr string) (Broker, error) {
+	u, err := url.Parse(connstr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid connection string: %w", err)
+	}
+
+	switch u.Scheme {
+	case "amqp", "amqps":
+		conn, err := amqp.Dial(connstr)
+		if err != nil {
+			return nil, err
+		}
+		b, err := NewAMQPBroker(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return b, nil
+	case "stomp", "stomp+ssl":
+		return DialSTOMP(u)
+	default:
+		return nil, fmt.Errorf("%w: %q", UnsupportedSchemeErr, u.Scheme)
+	}
+}
+
+type amqpBroker struct {
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+// NewAMQPBroker wraps an existing AMQP connection. Closing the broker closes
+// the connection.
+func NewAMQPBroker(conn *amqp.Connection) (Broker, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return &amqpBroker{conn: conn, ch: ch}, nil
+}
+
+func (b *amqpBroker) Publish(exchange string, key string, contentType string, body []byte) error {
+	return publish(b.ch, exchange, key, contentType, body)
+}
+
+func (b *amqpBroker) Subscribe(
+	exchange string,
+	key string,
+	queueName string,
+	simpleQueueType QueueType,
+	handler func(Delivery) AckType,
+) error {
+	ch, queue, err := DeclareAndBind(
+		b.conn,
+		exchange,
+		queueName,
+		key,
+		simpleQueueType,
+	)
+	if err != nil {
+		return err
+	}
+
+	ch.Qos(10, 0, false)
+	deliveryCh, err := ch.Consume(
+		queue.Name,
+		"",
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for delivery := range deliveryCh {
+			acktype := handler(Delivery{Body: delivery.Body, RoutingKey: delivery.RoutingKey})
+
+			switch acktype {
+			case AckTypeAck:
+				delivery.Ack(false)
+			case AckTypeNackRequeue:
+				delivery.Nack(false, true)
+			case AckTypeNackDiscard:
+				delivery.Nack(false, false)
+			default:
+				delivery.Ack(false)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// InspectQueue looks a queue up with a passive declare, which fails if it
+// doesn't exist.
+func (b *amqpBroker) InspectQueue(name string) (QueueInfo, error) {
+	// A failed passive declare closes the channel, so every queue gets its
+	// own.
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return QueueInfo{}, err
+	}
+	queue, err := ch.QueueDeclarePassive(name, true, false, false, false, nil)
+	if err != nil {
+		return QueueInfo{}, err
+	}
+	ch.Close()
+	return QueueInfo{Name: queue.Name, Messages: queue.Messages, Consumers: queue.Consumers}, nil
+}
+
+func (b *amqpBroker) IsClosed() bool {
+	return b.conn.IsClosed()
+}
+
+func (b *amqpBroker) Close() error {
+	return b.conn.Close()
+}
+
+func PublishBrokerJSON[T any](b Broker, exchange string, key string, val T) error {
+	body, err := marshalJSON(val)
+	if err != nil {
+		return err
+	}
+
+	return b.Publish(exchange, key, contentTypeJSON, body)
+}
+
+func PublishBrokerGob[T any](b Broker, exchange string, key string, val T) error {
+	body, err := marshalGob(val)
+	if err != nil {
+		return err
+	}
+
+	return b.Publish(exchange, key, contentTypeGob, body)
+}
+
+func SubscribeBrokerJSON[T any](
+	b Broker,
+	exchange string,
+	key string,
+	queueName string,
+	simpleQueueType QueueType,
+	handler func(T) AckType,
+) error {
+	return SubscribeBrokerJSONWithKey(b, exchange, key, queueName, simpleQueueType, func(val T, _ string) AckType {
+		return handler(val)
+	})
+}
+
+// SubscribeBrokerJSONWithKey is SubscribeBrokerJSON for handlers that need
+// each message's routing key, e.g. to check who sent it. Broker permissions
+// can tie routing keys to a login, which message bodies can't be.
+func SubscribeBrokerJSONWithKey[T any](
+	b Broker,
+	exchange string,
+	key string,
+	queueName string,
+	simpleQueueType QueueType,
+	handler func(val T, routingKey string) AckType,
+) error {
+	return b.Subscribe(
+		exchange,
+		key,
+		queueName,
+		simpleQueueType,
+		decodeWith(unmarshalJSON[T], handler),
+	)
+}
+
+func SubscribeBrokerGob[T any](
+	b Broker,
+	exchange string,
+	key string,
+	queueName string,
+	simpleQueueType QueueType,
+	handler func(T) AckType,
+) error {
+	return b.Subscribe(
+		exchange,
+		key,
+		queueName,
+		simpleQueueType,
+		decodeWith(unmarshalGob[T], func(val T, _ string) AckType {
+			return handler(val)
+		}),
+	)
+}
+
+func decodeWith[T any](
+	unmarshaller func([]byte) (T, error),
+	handler func(T, string) AckType,
+) func(Delivery) AckType {
+	return func(d Delivery) AckType {
+		val, err := unmarshaller(d.Body)
+		if err != nil {
+			fmt.Println(err)
+			return AckTypeNackDiscard
+		}
+		return handler(val, d.RoutingKey)
+	}
+}
@@ -28,63 +28,81 @@ const (
 
 var InvalidQueueTypeErr error = errors.New("Unknown queue type specified")
 
+const (
+	contentTypeJSON = "application/json"
+	contentTypeGob  = "application/gob"
+)
+
 func PublishJSON[T any](ch *amqp.Channel, exchange string, key string, val T) error {
+	body, err := marshalJSON(val)
+	if err != nil {
+		return err
+	}
 
-    err := publish[T](ch, exchange, key, val, func(t T) ([]byte, error) {
-        bytes, err := json.Marshal(val)
-        if err != nil {
-            return nil, err
-        }
+	return publish(ch, exchange, key, contentTypeJSON, body)
+}
 
-        return bytes, nil
-    })
-    if err != nil {
-        return err
-    }
+func PublishGob[T any](ch *amqp.Channel, exchange string, key string, val T) error {
+	body, err := marshalGob(val)
+	if err != nil {
+		return err
+	}
 
-    return nil
+	return publish(ch, exchange, key, contentTypeGob, body)
 }
 
-func PublishGob[T any](ch *amqp.Channel, exchange string, key string, val T) error {
-    err := publish[T](ch, exchange, key, val, func(t T) ([]byte, error) {
-        var buf bytes.Buffer
-        encoder := gob.NewEncoder(&buf)
-        err := encoder.Encode(val)
-        if err != nil {
-            return nil, err
-        }
+func marshalJSON[T any](val T) ([]byte, error) {
+	return json.Marshal(val)
+}
 
-        return buf.Bytes(), nil
-    })
-    if err != nil {
-        return err
-    }
+func unmarshalJSON[T any](b []byte) (T, error) {
+	var val T
+	err := json.Unmarshal(b, &val)
+	if err != nil {
+		return val, err
+	}
 
-    return nil
+	return val, nil
 }
 
-func publish[T any](
-    ch *amqp.Channel,
-    exchange string,
-    key string,
-    val T,
-    marshaller func(T) ([]byte, error),
-) error {
+func marshalGob[T any](val T) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := gob.NewEncoder(&buf)
+	err := encoder.Encode(val)
+	if err != nil {
+		return nil, err
+	}
 
-    bytes, err := marshaller(val)
-    if err != nil {
-        return err
-    }
+	return buf.Bytes(), nil
+}
+
+func unmarshalGob[T any](b []byte) (T, error) {
+	buf := bytes.NewBuffer(b)
+	decoder := gob.NewDecoder(buf)
+	var val T
+	err := decoder.Decode(&val)
+	if err != nil {
+		return val, err
+	}
+	return val, nil
+}
 
-	err = ch.PublishWithContext(
+func publish(
+	ch *amqp.Channel,
+	exchange string,
+	key string,
+	contentType string,
+	body []byte,
+) error {
+	err := ch.PublishWithContext(
 		context.Background(),
 		exchange,
 		key,
 		false,
 		false,
 		amqp.Publishing{
-			ContentType: "application/gob",
-			Body:        bytes,
+			ContentType: contentType,
+			Body:        body,
 		},
 	)
 
@@ -0,0 +1,235 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// RabbitMQ's pseudo-queue for direct reply-to. Consuming from it lets a
+// channel receive replies without declaring a queue of its own.
+const directReplyTo = "amq.rabbitmq.reply-to"
+
+// Replies carry the handler's error, if any, in this header.
+const rpcErrorHeader = "x-rpc-error"
+
+var CallTimeoutErr error = errors.New("Timed out waiting for a reply")
+
+// RemoteError is an error returned by the handler answering a Call.
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return e.Message
+}
+
+// Call publishes req to exchange with key and waits for the response from
+// whoever Serves it. If the context ends first, Call returns CallTimeoutErr,
+// and a request still waiting in a queue expires with it.
+func Call[Req any, Resp any](
+	ctx context.Context,
+	b Broker,
+	exchange string,
+	key string,
+	req Req,
+) (Resp, error) {
+	var resp Resp
+	body, err := marshalJSON(req)
+	if err != nil {
+		return resp, err
+	}
+
+	reply, err := b.Call(ctx, exchange, key, body)
+	if err != nil {
+		return resp, err
+	}
+	return unmarshalJSON[Resp](reply)
+}
+
+// Serve answers every Call made to exchange with key, reading requests from
+// queueName. A handler's error is passed back to the caller as a
+// RemoteError.
+func Serve[Req any, Resp any](
+	b Broker,
+	exchange string,
+	key string,
+	queueName string,
+	simpleQueueType QueueType,
+	handler func(Req) (Resp, error),
+) error {
+	return ServeWithKey(b, exchange, key, queueName, simpleQueueType, func(req Req, _ string) (Resp, error) {
+		return handler(req)
+	})
+}
+
+// ServeWithKey is Serve for handlers that need each request's routing key,
+// e.g. to check who sent it.
+func ServeWithKey[Req any, Resp any](
+	b Broker,
+	exchange string,
+	key string,
+	queueName string,
+	simpleQueueType QueueType,
+	handler func(req Req, routingKey string) (Resp, error),
+) error {
+	return b.Serve(exchange, key, queueName, simpleQueueType, func(d Delivery) ([]byte, error) {
+		req, err := unmarshalJSON[Req](d.Body)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := handler(req, d.RoutingKey)
+		if err != nil {
+			return nil, err
+		}
+		return marshalJSON(resp)
+	})
+}
+
+// Call sends the request with direct reply-to and matches the reply up by
+// correlation ID.
+func (b *amqpBroker) Call(ctx context.Context, exchange string, key string, body []byte) ([]byte, error) {
+	// Direct reply-to ties replies to the channel that made the request, so
+	// every call gets its own.
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+
+	replies, err := ch.Consume(directReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	correlationID, err := newCorrelationID()
+	if err != nil {
+		return nil, err
+	}
+	msg := amqp.Publishing{
+		ContentType:   contentTypeJSON,
+		CorrelationId: correlationID,
+		ReplyTo:       directReplyTo,
+		Body:          body,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		msg.Expiration = expiration(deadline)
+	}
+	err = ch.PublishWithContext(ctx, exchange, key, false, false, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w from %s", CallTimeoutErr, key)
+		case reply, ok := <-replies:
+			if !ok {
+				return nil, errors.New("Channel closed while waiting for a reply")
+			}
+			if reply.CorrelationId != correlationID {
+				continue
+			}
+			if message, ok := reply.Headers[rpcErrorHeader].(string); ok {
+				return nil, &RemoteError{Message: message}
+			}
+			return reply.Body, nil
+		}
+	}
+}
+
+func (b *amqpBroker) Serve(
+	exchange string,
+	key string,
+	queueName string,
+	simpleQueueType QueueType,
+	handler func(Delivery) ([]byte, error),
+) error {
+	ch, queue, err := DeclareAndBind(
+		b.conn,
+		exchange,
+		queueName,
+		key,
+		simpleQueueType,
+	)
+	if err != nil {
+		return err
+	}
+
+	ch.Qos(10, 0, false)
+	deliveryCh, err := ch.Consume(
+		queue.Name,
+		"",
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for delivery := range deliveryCh {
+			if delivery.ReplyTo == "" {
+				// Nobody is waiting for an answer.
+				delivery.Nack(false, false)
+				continue
+			}
+
+			reply := amqp.Publishing{
+				ContentType:   contentTypeJSON,
+				CorrelationId: delivery.CorrelationId,
+			}
+			body, err := handler(Delivery{Body: delivery.Body, RoutingKey: delivery.RoutingKey})
+			if err != nil {
+				reply.Headers = amqp.Table{rpcErrorHeader: err.Error()}
+			} else {
+				reply.Body = body
+			}
+
+			// Callers over STOMP name their reply queue with a
+			// destination prefix that AMQP doesn't use.
+			replyTo := strings.TrimPrefix(delivery.ReplyTo, stompReplyQueuePrefix)
+			err = ch.PublishWithContext(
+				context.Background(),
+				"",
+				replyTo,
+				false,
+				false,
+				reply,
+			)
+			if err != nil {
+				fmt.Println(err)
+			}
+			delivery.Ack(false)
+		}
+	}()
+
+	return nil
+}
+
+// expiration is a per-message TTL, in milliseconds, that runs out at
+// deadline.
+func expiration(deadline time.Time) string {
+	ttl := time.Until(deadline).Milliseconds()
+	return strconv.FormatInt(max(ttl, 1), 10)
+}
+
+func newCorrelationID() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,472 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-stomp/stomp/v3/frame"
+)
+
+const defaultSTOMPPort = "61613"
+
+const (
+	// Replies to Calls come back on this temporary queue, which RabbitMQ
+	// creates for the connection the first time a SEND names it.
+	stompReplyTo = "/temp-queue/rpc"
+	// RabbitMQ prefixes the reply-to of requests sent over STOMP with this,
+	// both to name the destination to reply to and for AMQP consumers.
+	stompReplyQueuePrefix = "/reply-queue/"
+	// How long Close waits for the server to confirm a DISCONNECT.
+	stompDisconnectTimeout = 5 * time.Second
+	// Matches the prefetch-count each subscription asks for, so delivering
+	// a message never blocks the read loop.
+	stompPrefetch = 10
+)
+
+var STOMPClosedErr error = errors.New("STOMP connection closed")
+
+// stompBroker is a STOMP 1.2 client working directly on frames, so every
+// ACK and NACK carries exactly the headers RabbitMQ needs.
+type stompBroker struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+	w       *frame.Writer
+
+	mu       sync.Mutex
+	nextID   int
+	subs     map[string]chan *frame.Frame
+	calls    map[string]chan *frame.Frame
+	receipts map[string]chan struct{}
+
+	// done is closed once the connection is lost or closed.
+	done chan struct{}
+}
+
+// DialSTOMP connects to a STOMP server such as RabbitMQ's rabbitmq_stomp
+// plugin. The URL path, if any, names the virtual host.
+func DialSTOMP(u *url.URL) (Broker, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), defaultSTOMPPort)
+	}
+
+	var netConn net.Conn
+	var err error
+	if u.Scheme == "stomp+ssl" {
+		netConn, err = tls.Dial("tcp", addr, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		netConn, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	vhost := strings.TrimPrefix(u.Path, "/")
+	if vhost == "" {
+		vhost = "/"
+	}
+	connect := frame.New(
+		frame.CONNECT,
+		frame.AcceptVersion, "1.2",
+		frame.Host, vhost,
+		frame.HeartBeat, "0,0",
+	)
+	if u.User != nil {
+		password, _ := u.User.Password()
+		connect.Header.Set(frame.Login, u.User.Username())
+		connect.Header.Set(frame.Passcode, password)
+	}
+
+	b, err := newSTOMPBroker(netConn, connect)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// newSTOMPBroker sends connect over conn and, once the server accepts it,
+// starts reading frames.
+func newSTOMPBroker(conn net.Conn, connect *frame.Frame) (*stompBroker, error) {
+	r := frame.NewReader(conn)
+	w := frame.NewWriter(conn)
+
+	err := w.Write(connect)
+	if err != nil {
+		return nil, err
+	}
+	var connected *frame.Frame
+	for connected == nil {
+		// Skip any heart-beats.
+		connected, err = r.Read()
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch connected.Command {
+	case frame.CONNECTED:
+	case frame.ERROR:
+		return nil, fmt.Errorf("STOMP server refused connection: %s", connected.Header.Get(frame.Message))
+	default:
+		return nil, fmt.Errorf("Expected CONNECTED frame, got %s", connected.Command)
+	}
+
+	b := &stompBroker{
+		conn:     conn,
+		w:        w,
+		subs:     map[string]chan *frame.Frame{},
+		calls:    map[string]chan *frame.Frame{},
+		receipts: map[string]chan struct{}{},
+		done:     make(chan struct{}),
+	}
+	go b.readLoop(r)
+	return b, nil
+}
+
+// readLoop hands every frame from the server to whoever is waiting for it
+// until the connection ends.
+func (b *stompBroker) readLoop(r *frame.Reader) {
+	defer b.shutdown()
+
+	for {
+		f, err := r.Read()
+		if err != nil {
+			return
+		}
+		if f == nil {
+			// Heart-beat
+			continue
+		}
+
+		switch f.Command {
+		case frame.MESSAGE:
+			b.deliver(f)
+		case frame.RECEIPT:
+			id := f.Header.Get(frame.ReceiptId)
+			b.mu.Lock()
+			receipt, ok := b.receipts[id]
+			delete(b.receipts, id)
+			b.mu.Unlock()
+			if ok {
+				close(receipt)
+			}
+		case frame.ERROR:
+			// The server closes the connection after an ERROR.
+			fmt.Printf("STOMP error: %s\n", f.Header.Get(frame.Message))
+			return
+		}
+	}
+}
+
+func (b *stompBroker) deliver(f *frame.Frame) {
+	sub := f.Header.Get(frame.Subscription)
+
+	if sub == stompReplyTo {
+		b.mu.Lock()
+		reply, ok := b.calls[f.Header.Get("correlation-id")]
+		b.mu.Unlock()
+		if !ok {
+			return
+		}
+		select {
+		case reply <- f:
+		default:
+			// Only the first reply counts.
+		}
+		return
+	}
+
+	b.mu.Lock()
+	msgs, ok := b.subs[sub]
+	b.mu.Unlock()
+	if ok {
+		// Only readLoop closes msgs, so this can't race with shutdown.
+		msgs <- f
+	}
+}
+
+// shutdown closes the connection and stops every subscription. Only
+// readLoop calls it.
+func (b *stompBroker) shutdown() {
+	b.conn.Close()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	close(b.done)
+	for id, msgs := range b.subs {
+		close(msgs)
+		delete(b.subs, id)
+	}
+}
+
+// send writes a whole frame to the server.
+func (b *stompBroker) send(f *frame.Frame) error {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+	select {
+	case <-b.done:
+		return STOMPClosedErr
+	default:
+	}
+	return b.w.Write(f)
+}
+
+// newID returns an ID for a subscription or receipt that is unique to this
+// connection.
+func (b *stompBroker) newID(prefix string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	return prefix + strconv.Itoa(b.nextID)
+}
+
+// stompDestination maps an exchange and routing key onto RabbitMQ's
+// /exchange destinations, so topic keys land on peril_topic and direct keys
+// on peril_direct exactly as they would over AMQP.
+func stompDestination(exchange string, key string) string {
+	if key == "" {
+		return fmt.Sprintf("/exchange/%s", exchange)
+	}
+	return fmt.Sprintf("/exchange/%s/%s", exchange, key)
+}
+
+// sendFrame builds a SEND frame. The content-length lets bodies, such as
+// gobs, contain NUL bytes.
+func sendFrame(destination string, contentType string, body []byte) *frame.Frame {
+	f := frame.New(
+		frame.SEND,
+		frame.Destination, destination,
+		frame.ContentType, contentType,
+		frame.ContentLength, strconv.Itoa(len(body)),
+	)
+	f.Body = body
+	return f
+}
+
+func (b *stompBroker) Publish(exchange string, key string, contentType string, body []byte) error {
+	return b.send(sendFrame(stompDestination(exchange, key), contentType, body))
+}
+
+func (b *stompBroker) Subscribe(
+	exchange string,
+	key string,
+	queueName string,
+	simpleQueueType QueueType,
+	handler func(Delivery) AckType,
+) error {
+	return b.subscribe(exchange, key, queueName, simpleQueueType, func(msg *frame.Frame) AckType {
+		return handler(delivery(exchange, msg))
+	})
+}
+
+// subscribe consumes from queueName, bound to exchange with key, and
+// acknowledges each message as handler says.
+func (b *stompBroker) subscribe(
+	exchange string,
+	key string,
+	queueName string,
+	simpleQueueType QueueType,
+	handler func(*frame.Frame) AckType,
+) error {
+	id := b.newID("sub-")
+	sub := frame.New(
+		frame.SUBSCRIBE,
+		frame.Id, id,
+		frame.Destination, stompDestination(exchange, key),
+		frame.Ack, frame.AckClientIndividual,
+		"x-dead-letter-exchange", "peril_dlx",
+		"prefetch-count", strconv.Itoa(stompPrefetch),
+	)
+
+	switch simpleQueueType {
+	case QueueTypeDurable:
+		sub.Header.Set("durable", "true")
+		sub.Header.Set("auto-delete", "false")
+		sub.Header.Set("exclusive", "false")
+	case QueueTypeTransient:
+		sub.Header.Set("durable", "false")
+		sub.Header.Set("auto-delete", "true")
+		sub.Header.Set("exclusive", "true")
+	default:
+		return InvalidQueueTypeErr
+	}
+
+	if queueName != "" {
+		sub.Header.Set("x-queue-name", queueName)
+	}
+
+	msgs := make(chan *frame.Frame, stompPrefetch)
+	b.mu.Lock()
+	b.subs[id] = msgs
+	b.mu.Unlock()
+
+	err := b.send(sub)
+	if err != nil {
+		b.mu.Lock()
+		delete(b.subs, id)
+		b.mu.Unlock()
+		return err
+	}
+
+	go func() {
+		for msg := range msgs {
+			acktype := handler(msg)
+
+			var err error
+			switch acktype {
+			case AckTypeAck:
+				err = b.ack(msg)
+			case AckTypeNackRequeue:
+				err = b.nack(msg, true)
+			case AckTypeNackDiscard:
+				err = b.nack(msg, false)
+			default:
+				err = b.ack(msg)
+			}
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// delivery returns the body of msg along with the routing key it was
+// published with, which RabbitMQ gives as the last part of its destination.
+func delivery(exchange string, msg *frame.Frame) Delivery {
+	prefix := stompDestination(exchange, "") + "/"
+	return Delivery{
+		Body:       msg.Body,
+		RoutingKey: strings.TrimPrefix(msg.Header.Get(frame.Destination), prefix),
+	}
+}
+
+func (b *stompBroker) ack(msg *frame.Frame) error {
+	return b.send(frame.New(frame.ACK, frame.Id, msg.Header.Get(frame.Ack)))
+}
+
+// nack rejects msg. Without requeue, RabbitMQ dead-letters it as it would
+// over AMQP.
+func (b *stompBroker) nack(msg *frame.Frame, requeue bool) error {
+	return b.send(frame.New(
+		frame.NACK,
+		frame.Id, msg.Header.Get(frame.Ack),
+		"requeue", strconv.FormatBool(requeue),
+	))
+}
+
+// Call sends the request with a reply-to of the connection's temporary
+// queue and matches the reply up by correlation ID.
+func (b *stompBroker) Call(ctx context.Context, exchange string, key string, body []byte) ([]byte, error) {
+	correlationID, err := newCorrelationID()
+	if err != nil {
+		return nil, err
+	}
+	req := sendFrame(stompDestination(exchange, key), contentTypeJSON, body)
+	req.Header.Set("reply-to", stompReplyTo)
+	req.Header.Set("correlation-id", correlationID)
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("expiration", expiration(deadline))
+	}
+
+	replies := make(chan *frame.Frame, 1)
+	b.mu.Lock()
+	b.calls[correlationID] = replies
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.calls, correlationID)
+		b.mu.Unlock()
+	}()
+
+	err = b.send(req)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w from %s", CallTimeoutErr, key)
+	case <-b.done:
+		return nil, errors.New("Connection closed while waiting for a reply")
+	case reply := <-replies:
+		if message, ok := reply.Header.Contains(rpcErrorHeader); ok {
+			return nil, &RemoteError{Message: message}
+		}
+		return reply.Body, nil
+	}
+}
+
+func (b *stompBroker) Serve(
+	exchange string,
+	key string,
+	queueName string,
+	simpleQueueType QueueType,
+	handler func(Delivery) ([]byte, error),
+) error {
+	return b.subscribe(exchange, key, queueName, simpleQueueType, func(msg *frame.Frame) AckType {
+		replyTo := msg.Header.Get("reply-to")
+		if replyTo == "" {
+			// Nobody is waiting for an answer.
+			return AckTypeNackDiscard
+		}
+		// Callers over AMQP give a bare queue name.
+		if !strings.HasPrefix(replyTo, "/") {
+			replyTo = stompReplyQueuePrefix + replyTo
+		}
+
+		body, err := handler(delivery(exchange, msg))
+		reply := sendFrame(replyTo, contentTypeJSON, body)
+		reply.Header.Set("correlation-id", msg.Header.Get("correlation-id"))
+		if err != nil {
+			reply.Header.Set(rpcErrorHeader, err.Error())
+		}
+
+		err = b.send(reply)
+		if err != nil {
+			fmt.Println(err)
+		}
+		return AckTypeAck
+	})
+}
+
+func (b *stompBroker) IsClosed() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close disconnects gracefully, waiting briefly for the server to confirm
+// it has seen every frame sent before.
+func (b *stompBroker) Close() error {
+	id := b.newID("disconnect-")
+	receipt := make(chan struct{})
+	b.mu.Lock()
+	b.receipts[id] = receipt
+	b.mu.Unlock()
+
+	err := b.send(frame.New(frame.DISCONNECT, frame.Receipt, id))
+	if err == nil {
+		select {
+		case <-receipt:
+		case <-b.done:
+		case <-time.After(stompDisconnectTimeout):
+		}
+	}
+	b.conn.Close()
+	<-b.done
+	return err
+}
@@ -11,3 +11,86 @@ type GameLog struct {
 	Message     string
 	Username    string
 }
+
+type PresenceKind string
+
+const (
+	PresenceJoin      PresenceKind = "join"
+	PresenceHeartbeat PresenceKind = "heartbeat"
+	PresenceLeave     PresenceKind = "leave"
+)
+
+type PlayerPresence struct {
+	Username string
+	// GameID is the game the player is playing in.
+	GameID string
+	// Spectator is set for someone watching the game rather than playing.
+	Spectator   bool
+	Kind        PresenceKind
+	CurrentTime time.Time
+}
+
+type Announcement struct {
+	CurrentTime time.Time
+	Message     string
+}
+
+type Kick struct {
+	Reason string
+}
+
+type LobbyRequestKind string
+
+const (
+	LobbyList   LobbyRequestKind = "list"
+	LobbyCreate LobbyRequestKind = "create"
+	LobbyWatch  LobbyRequestKind = "watch"
+	LobbyLoad   LobbyRequestKind = "load"
+)
+
+// LobbyRequest asks the server for the games it is running, for a new one,
+// whether the player may watch a game or to pick up a game the player saved.
+// It is sent with pubsub.Call and answered with a LobbyReply.
+type LobbyRequest struct {
+	Username string
+	Kind     LobbyRequestKind
+	// GameID is the game to watch.
+	GameID string `json:",omitempty"`
+	// Save is the content of the save file to load.
+	Save []byte `json:",omitempty"`
+}
+
+type GameSummary struct {
+	GameID  string
+	Map     string
+	Players int
+	Over    bool
+}
+
+type LobbyReply struct {
+	Games []GameSummary
+	// Created is the ID of the game made for a create or load request.
+	Created string
+}
+
+// MatchRequest puts a player in the matchmaking queue, or takes them out of
+// it if Cancel is set. Players are only matched with others who asked for
+// the same player count and map.
+type MatchRequest struct {
+	Username string
+	// Players is how many players the game should have, 2 if unset.
+	Players int
+	// Map names a map in the server's maps directory, or the server's
+	// default map if empty.
+	Map    string
+	Cancel bool
+}
+
+// Match tells a player which game matchmaking put them in, or why their
+// request was turned down.
+type Match struct {
+	GameID  string
+	Map     string
+	Players []string
+	Error   string
+}
@@ -1,16 +1,62 @@
 package routing
 
+import (
+	"strings"
+	"time"
+)
+
 const (
 	ArmyMovesPrefix = "army_moves"
 
 	WarRecognitionsPrefix = "war"
 
+	WarResultsPrefix = "war_results"
+
 	PauseKey = "pause"
 
+	IntentsPrefix = "intents"
+
+	StatePrefix = "state"
+
+	GameInfoPrefix = "game_info"
+
+	TurnKey = "turn"
+
+	GameOverKey = "game_over"
+
+	DiplomacyPrefix = "diplomacy"
+
 	GameLogSlug = "game_logs"
+
+	PresencePrefix = "presence"
+
+	AnnouncementKey = "announcement"
+
+	KickPrefix = "kick"
+
+	LobbyPrefix = "lobby"
+
+	MatchmakingPrefix = "matchmaking"
+
+	MatchesPrefix = "matches"
 )
 
 const (
 	ExchangePerilDirect = "peril_direct"
 	ExchangePerilTopic  = "peril_topic"
 )
+
+const (
+	// Clients publish a heartbeat this often while connected.
+	PresenceHeartbeatInterval = 5 * time.Second
+
+	// The server forgets players it hasn't heard from in this long.
+	PresenceTimeout = 3 * PresenceHeartbeatInterval
+)
+
+// GameKey builds a routing key or queue name scoped to a single game, so
+// several games can share the exchanges. For example,
+// GameKey(ArmyMovesPrefix, "1a2b", "alice") is "army_moves.1a2b.alice".
+func GameKey(prefix string, gameID string, rest ...string) string {
+	return strings.Join(append([]string{prefix, gameID}, rest...), ".")
+}